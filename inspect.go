@@ -0,0 +1,64 @@
+package main
+
+import (
+	"blackdark/turbostat-exporter/internal"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// runInspect parses a captured turbostat output file like a collection would
+// and prints how it was parsed and the metrics it exports. It returns the exit
+// code, using the same codes as the once command.
+func runInspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s inspect <capture.tsv|->\n", os.Args[0])
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	path := flags.Arg(0)
+	var (
+		content []byte
+		err     error
+	)
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to read %s", path)
+		return exitUsage
+	}
+
+	registry := prometheus.NewRegistry()
+	output := programOutput{command: "inspect " + path, stdout: string(content), combined: string(content)}
+	parseErr := processOutput(newParser(), newExporter(registry), time.Now(), output, nil)
+
+	if err := debugStore.Latest().WriteReport(os.Stdout); err != nil {
+		log.Error().Err(err).Msg("Failed to write report")
+		return exitOutputFailed
+	}
+	if parseErr != nil {
+		return exitParseFailed
+	}
+
+	fmt.Println("\nExported metrics:")
+	if err := internal.WriteSnapshot(os.Stdout, "prom", snapshotStore.Latest(), registry); err != nil {
+		log.Error().Err(err).Msg("Failed to write metrics")
+		return exitOutputFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,227 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AggregateOptions configures an Aggregator.
+type AggregateOptions struct {
+	// Columns are the turbostat columns to aggregate, e.g. PkgWatt.
+	Columns []string
+	// Window is the time span of the aggregation. 0 aggregates the samples
+	// since the previous scrape, see Aggregator.Rotate.
+	Window time.Duration
+	// Quantiles like 0.5 or 0.99 are exported besides min, max, avg and last.
+	Quantiles []float64
+	// MaxSamples bounds the samples kept per series, e.g. if nobody scrapes.
+	MaxSamples int
+}
+
+// Aggregator keeps the samples of selected columns of short collections and
+// exports their min, max, avg, last value and quantiles, so a 15s scrape
+// still sees a 1s power spike. The metrics follow the names and labels of
+// the exporter with a suffix, e.g. turbostat_packages_max{package="0",type="pkgwatt"}.
+type Aggregator struct {
+	opts    AggregateOptions
+	columns map[string]bool
+	now     func() time.Time
+
+	mu     sync.Mutex
+	series map[aggregateKey]*aggregateSeries
+	// windowStart is the time of the previous scrape if opts.Window is 0
+	windowStart time.Time
+	descs       map[string]*prometheus.Desc
+}
+
+type aggregateKey struct {
+	category, pkg, core, cpu, column string
+}
+
+type aggregateSeries struct {
+	times  []time.Time
+	values []float64
+}
+
+func NewAggregator(opts AggregateOptions) *Aggregator {
+	opts.MaxSamples = max(opts.MaxSamples, 1)
+	a := &Aggregator{
+		opts:    opts,
+		columns: map[string]bool{},
+		now:     time.Now,
+		series:  map[aggregateKey]*aggregateSeries{},
+		descs:   map[string]*prometheus.Desc{},
+	}
+	for _, c := range opts.Columns {
+		a.columns[c] = true
+	}
+	a.windowStart = a.now()
+	return a
+}
+
+// Add records the selected columns of a collection.
+func (a *Aggregator) Add(snap *Snapshot) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	seen := map[aggregateKey]bool{}
+	for i := range snap.Rows {
+		row := &snap.Rows[i]
+		for _, values := range []map[string]float64{row.Other, row.OtherPercent} {
+			for column, v := range values {
+				if !a.columns[column] {
+					continue
+				}
+				key := aggregateKey{category: row.Category, pkg: row.Pkg, core: row.Core, cpu: row.CPU, column: column}
+				s, ok := a.series[key]
+				if !ok {
+					s = &aggregateSeries{}
+					a.series[key] = s
+				}
+				s.times = append(s.times, snap.Timestamp)
+				s.values = append(s.values, v)
+				seen[key] = true
+			}
+		}
+	}
+
+	start := a.start()
+	for key, s := range a.series {
+		s.prune(start, a.opts.MaxSamples)
+		// series which disappeared, e.g. offline CPUs, are dropped once all
+		// their samples left the window
+		if !seen[key] && (len(s.times) == 0 || s.times[len(s.times)-1].Before(start)) {
+			delete(a.series, key)
+		}
+	}
+}
+
+// Rotate starts a new window. It is called after every scrape if the window
+// is the time since the previous scrape.
+func (a *Aggregator) Rotate() {
+	if a.opts.Window > 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.windowStart = a.now()
+}
+
+// start returns the beginning of the current window. a.mu must be held.
+func (a *Aggregator) start() time.Time {
+	if a.opts.Window > 0 {
+		return a.now().Add(-a.opts.Window)
+	}
+	return a.windowStart
+}
+
+// prune drops samples before start and beyond maxSamples, always keeping the
+// newest sample so the last value survives a window without collections.
+func (s *aggregateSeries) prune(start time.Time, maxSamples int) {
+	drop := 0
+	for drop < len(s.times)-1 && s.times[drop].Before(start) {
+		drop++
+	}
+	drop = max(drop, len(s.times)-maxSamples)
+	if drop > 0 {
+		s.times = slices.Delete(s.times, 0, drop)
+		s.values = slices.Delete(s.values, 0, drop)
+	}
+}
+
+// Describe sends no descriptions, the exported series depend on the
+// collections, which makes the Aggregator an unchecked collector.
+func (a *Aggregator) Describe(chan<- *prometheus.Desc) {}
+
+func (a *Aggregator) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	start := a.start()
+	for key, s := range a.series {
+		if len(s.values) == 0 {
+			continue
+		}
+		// without samples in the window the last value is repeated instead
+		// of letting the series disappear between collections
+		first := len(s.times) - 1
+		for i, t := range s.times {
+			if !t.Before(start) {
+				first = i
+				break
+			}
+		}
+		values := s.values[first:]
+
+		name, labelNames, labelValues := aggregateMetric(key)
+		sorted := slices.Sorted(slices.Values(values))
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		for _, stat := range []struct {
+			suffix, help string
+			value        float64
+		}{
+			{"_min", "Minimum", sorted[0]},
+			{"_max", "Maximum", sorted[len(sorted)-1]},
+			{"_avg", "Average", sum / float64(len(values))},
+			{"_last", "Latest value", values[len(values)-1]},
+		} {
+			ch <- prometheus.MustNewConstMetric(a.desc(name+stat.suffix, stat.help, labelNames), prometheus.GaugeValue, stat.value, labelValues...)
+		}
+		for _, q := range a.opts.Quantiles {
+			desc := a.desc(name+"_quantile", "Quantiles", append(slices.Clone(labelNames), "quantile"))
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, quantile(sorted, q),
+				append(slices.Clone(labelValues), strconv.FormatFloat(q, 'g', -1, 64))...)
+		}
+	}
+}
+
+// desc returns the cached description of a metric. a.mu must be held.
+func (a *Aggregator) desc(name, stat string, labelNames []string) *prometheus.Desc {
+	if d, ok := a.descs[name]; ok {
+		return d
+	}
+	window := "since the previous scrape"
+	if a.opts.Window > 0 {
+		window = "of the last " + a.opts.Window.String()
+	}
+	d := prometheus.NewDesc(name, fmt.Sprintf("%s of the samples %s.", stat, window), labelNames, nil)
+	a.descs[name] = d
+	return d
+}
+
+// aggregateMetric returns the exporter metric name and labels of a series.
+func aggregateMetric(key aggregateKey) (string, []string, []string) {
+	name := familyName(key.category, strings.Contains(key.column, "%"))
+
+	typ := sanitizeHeader(key.column)
+	switch key.category {
+	case "package":
+		return name, []string{"package", "type"}, []string{key.pkg, typ}
+	case "core":
+		return name, []string{"package", "core", "type"}, []string{key.pkg, key.core, typ}
+	case "cpu":
+		return name, []string{"package", "core", "cpu", "type"}, []string{key.pkg, key.core, key.cpu, typ}
+	default:
+		return name, []string{"type"}, []string{typ}
+	}
+}
+
+// quantile interpolates linearly between the closest ranks of sorted.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lower := int(pos)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(lower)
+	return sorted[lower] + frac*(sorted[lower+1]-sorted[lower])
+}
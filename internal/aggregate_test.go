@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func aggregateSnapshot(ts time.Time, watts, busy float64) *Snapshot {
+	return &Snapshot{Timestamp: ts, Rows: []TurbostatRow{
+		{Category: "package", Pkg: "0", Other: map[string]float64{"PkgWatt": watts, "CorWatt": 1}, OtherPercent: map[string]float64{"Busy%": busy}},
+	}}
+}
+
+func TestAggregator_SinceLastScrape(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := NewAggregator(AggregateOptions{Columns: []string{"PkgWatt", "Busy%"}, Quantiles: []float64{0.5}, MaxSamples: 100})
+	a.now = func() time.Time { return now }
+	a.windowStart = now
+	registry := prometheus.NewPedanticRegistry()
+	prefixed(registry).MustRegister(a)
+
+	for i, watts := range []float64{10, 40, 20, 30} {
+		a.Add(aggregateSnapshot(now.Add(time.Duration(i+1)*time.Second), watts, 5))
+	}
+	now = now.Add(5 * time.Second)
+
+	expected := `
+# HELP turbostat_packages_avg Average of the samples since the previous scrape.
+# TYPE turbostat_packages_avg gauge
+turbostat_packages_avg{package="0",type="pkgwatt"} 25
+# HELP turbostat_packages_last Latest value of the samples since the previous scrape.
+# TYPE turbostat_packages_last gauge
+turbostat_packages_last{package="0",type="pkgwatt"} 30
+# HELP turbostat_packages_max Maximum of the samples since the previous scrape.
+# TYPE turbostat_packages_max gauge
+turbostat_packages_max{package="0",type="pkgwatt"} 40
+# HELP turbostat_packages_min Minimum of the samples since the previous scrape.
+# TYPE turbostat_packages_min gauge
+turbostat_packages_min{package="0",type="pkgwatt"} 10
+# HELP turbostat_packages_quantile Quantiles of the samples since the previous scrape.
+# TYPE turbostat_packages_quantile gauge
+turbostat_packages_quantile{package="0",quantile="0.5",type="pkgwatt"} 25
+`
+	names := []string{"turbostat_packages_avg", "turbostat_packages_last", "turbostat_packages_max", "turbostat_packages_min", "turbostat_packages_quantile"}
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP turbostat_packages_percent_max Maximum of the samples since the previous scrape.
+# TYPE turbostat_packages_percent_max gauge
+turbostat_packages_percent_max{package="0",type="busy"} 5
+`), "turbostat_packages_percent_max"); err != nil {
+		t.Error(err)
+	}
+
+	// a scrape starts a new window, without new samples the last one is kept
+	a.Rotate()
+	now = now.Add(5 * time.Second)
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP turbostat_packages_min Minimum of the samples since the previous scrape.
+# TYPE turbostat_packages_min gauge
+turbostat_packages_min{package="0",type="pkgwatt"} 30
+`), "turbostat_packages_min"); err != nil {
+		t.Error(err)
+	}
+	a.Add(aggregateSnapshot(now, 50, 5))
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP turbostat_packages_min Minimum of the samples since the previous scrape.
+# TYPE turbostat_packages_min gauge
+turbostat_packages_min{package="0",type="pkgwatt"} 50
+`), "turbostat_packages_min"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAggregator_FixedWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := NewAggregator(AggregateOptions{Columns: []string{"PkgWatt"}, Window: 10 * time.Second, MaxSamples: 3})
+	a.now = func() time.Time { return now }
+
+	for i := range 20 {
+		now = time.Unix(1000+int64(i), 0)
+		a.Add(aggregateSnapshot(now, float64(i), 0))
+	}
+	a.Rotate()
+	registry := prometheus.NewRegistry()
+	prefixed(registry).MustRegister(a)
+
+	// the window holds 10 samples, MaxSamples keeps only the newest 3
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP turbostat_packages_min Minimum of the samples of the last 10s.
+# TYPE turbostat_packages_min gauge
+turbostat_packages_min{package="0",type="pkgwatt"} 17
+`), "turbostat_packages_min"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5}
+	for q, want := range map[float64]float64{0: 1, 0.5: 3, 0.9: 4.6, 1: 5} {
+		if got := quantile(sorted, q); got != want {
+			t.Errorf("quantile %v: expected %v, got %v", q, want, got)
+		}
+	}
+}
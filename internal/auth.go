@@ -3,48 +3,238 @@ package internal
 import (
 	"crypto/sha256"
 	"crypto/subtle"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
 )
 
-func BasicAuth(next http.HandlerFunc, basicAuthUsername, basicAuthPassword string) http.HandlerFunc {
+// AuthBackend checks the credentials of a single request. Backends are
+// combined by an AuthChain; the first backend accepting a request wins.
+type AuthBackend interface {
+	Authenticate(r *http.Request) bool
+	// Challenge returns the WWW-Authenticate value sent on rejected requests.
+	Challenge() string
+}
+
+// AuthChainOptions configures the behaviour shared by all backends of an AuthChain.
+type AuthChainOptions struct {
+	// ExemptPaths are served without authentication. An entry ending in "/"
+	// exempts every path below it.
+	ExemptPaths []string
+	// MaxFailures is the number of failed attempts a client IP may make within
+	// FailureWindow before it gets rejected without checking credentials.
+	// Zero disables rate limiting.
+	MaxFailures   int
+	FailureWindow time.Duration
+	// Registerer receives the auth failure counter. Nil skips registration.
+	Registerer prometheus.Registerer
+}
+
+type AuthChain struct {
+	backends    []AuthBackend
+	exemptPaths []string
+	limiter     *failureLimiter
+	failures    *prometheus.CounterVec
+}
+
+func NewAuthChain(opts AuthChainOptions, backends ...AuthBackend) *AuthChain {
+	chain := &AuthChain{
+		backends:    backends,
+		exemptPaths: opts.ExemptPaths,
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "exporter_auth_failures_total",
+			Help: "Number of rejected HTTP requests by reason.",
+		}, []string{"reason"}),
+	}
+	if opts.MaxFailures > 0 {
+		chain.limiter = newFailureLimiter(opts.MaxFailures, opts.FailureWindow)
+	}
+	if opts.Registerer != nil {
+		opts.Registerer.MustRegister(chain.failures)
+	}
+	return chain
+}
+
+// Enabled reports whether the chain has at least one backend and therefore
+// rejects unauthenticated requests.
+func (c *AuthChain) Enabled() bool {
+	return len(c.backends) > 0
+}
+
+func (c *AuthChain) isExempt(path string) bool {
+	for _, p := range c.exemptPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware wraps next so that every non-exempt request must be accepted by
+// at least one backend of the chain.
+func (c *AuthChain) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract the username and password from the request
-		// Authorization header. If no Authentication header is present
-		// or the header value is invalid, then the 'ok' return value
-		// will be false.
-		username, password, ok := r.BasicAuth()
-		if ok {
-			// Calculate SHA-256 hashes for the provided and expected
-			// usernames and passwords.
-			usernameHash := sha256.Sum256([]byte(username))
-			passwordHash := sha256.Sum256([]byte(password))
-			expectedUsernameHash := sha256.Sum256([]byte(basicAuthUsername))
-			expectedPasswordHash := sha256.Sum256([]byte(basicAuthPassword))
-
-			// Use the subtle.ConstantTimeCompare() function to check if
-			// the provided username and password hashes equal the
-			// expected username and password hashes. ConstantTimeCompare
-			// will return 1 if the values are equal, or 0 otherwise.
-			// Importantly, we should to do the work to evaluate both the
-			// username and password before checking the return values to
-			// avoid leaking information.
-			usernameMatch := (subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1)
-			passwordMatch := (subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1)
-
-			// If the username and password are correct, then call
-			// the next handler in the chain. Make sure to return
-			// afterwards, so that none of the code below is run.
-			if usernameMatch && passwordMatch {
+		if len(c.backends) == 0 || c.isExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		if c.limiter != nil && c.limiter.blocked(ip) {
+			c.failures.WithLabelValues("rate_limited").Inc()
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		for _, b := range c.backends {
+			if b.Authenticate(r) {
+				if c.limiter != nil {
+					c.limiter.reset(ip)
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
 		}
 
-		// If the Authentication header is not present, is invalid, or the
-		// username or password is wrong, then set a WWW-Authenticate
-		// header to inform the client that we expect them to use basic
-		// authentication and send a 401 Unauthorized response.
-		w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
+		c.failures.WithLabelValues("invalid_credentials").Inc()
+		if c.limiter != nil {
+			c.limiter.fail(ip)
+		}
+		log.Debug().Msgf("Rejected unauthenticated request from %s to %s", ip, r.URL.Path)
+
+		challenges := map[string]bool{}
+		for _, b := range c.backends {
+			if ch := b.Challenge(); !challenges[ch] {
+				challenges[ch] = true
+				w.Header().Add("WWW-Authenticate", ch)
+			}
+		}
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 	})
 }
+
+// BasicAuth protects next with a single static username and password.
+func BasicAuth(next http.HandlerFunc, basicAuthUsername, basicAuthPassword string) http.HandlerFunc {
+	chain := NewAuthChain(AuthChainOptions{}, NewBasicAuthBackend(basicAuthUsername, basicAuthPassword))
+	return chain.Middleware(next).ServeHTTP
+}
+
+type basicAuthBackend struct {
+	username string
+	password string
+}
+
+func NewBasicAuthBackend(username, password string) AuthBackend {
+	return &basicAuthBackend{username: username, password: password}
+}
+
+func (b *basicAuthBackend) Authenticate(r *http.Request) bool {
+	// Extract the username and password from the request
+	// Authorization header. If no Authentication header is present
+	// or the header value is invalid, then the 'ok' return value
+	// will be false.
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	// Importantly, we should to do the work to evaluate both the
+	// username and password before checking the return values to
+	// avoid leaking information.
+	usernameMatch := secureCompare(username, b.username)
+	passwordMatch := secureCompare(password, b.password)
+	return usernameMatch && passwordMatch
+}
+
+func (b *basicAuthBackend) Challenge() string {
+	return `Basic realm="restricted", charset="UTF-8"`
+}
+
+// secureCompare compares SHA-256 hashes of both values with
+// subtle.ConstantTimeCompare so neither the content nor the length of the
+// expected value leaks through timing.
+func secureCompare(given, expected string) bool {
+	givenHash := sha256.Sum256([]byte(given))
+	expectedHash := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(givenHash[:], expectedHash[:]) == 1
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type failureRecord struct {
+	count int
+	start time.Time
+}
+
+// failureLimiter counts failed attempts per client IP in a fixed window.
+type failureLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	now      func() time.Time
+	failures map[string]*failureRecord
+}
+
+func newFailureLimiter(maxFailures int, window time.Duration) *failureLimiter {
+	return &failureLimiter{
+		max:      maxFailures,
+		window:   window,
+		now:      time.Now,
+		failures: map[string]*failureRecord{},
+	}
+}
+
+func (l *failureLimiter) blocked(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec, ok := l.failures[ip]
+	if !ok {
+		return false
+	}
+	if l.now().Sub(rec.start) > l.window {
+		delete(l.failures, ip)
+		return false
+	}
+	return rec.count >= l.max
+}
+
+func (l *failureLimiter) fail(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	rec, ok := l.failures[ip]
+	if !ok || now.Sub(rec.start) > l.window {
+		l.prune(now)
+		l.failures[ip] = &failureRecord{count: 1, start: now}
+		return
+	}
+	rec.count++
+}
+
+func (l *failureLimiter) reset(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, ip)
+}
+
+// prune drops expired records so scanning clients can't grow the map forever.
+func (l *failureLimiter) prune(now time.Time) {
+	for ip, rec := range l.failures {
+		if now.Sub(rec.start) > l.window {
+			delete(l.failures, ip)
+		}
+	}
+}
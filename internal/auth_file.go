@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1" //nolint:gosec // htpasswd {SHA} entries are defined as SHA-1
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// reloadingFile caches the parsed content of a file and parses it again
+// whenever its content changes. The content is hashed instead of comparing
+// the modification time and size, which miss a rotation to a value of the
+// same length within the mtime granularity of the filesystem.
+type reloadingFile[T any] struct {
+	path  string
+	parse func([]byte) (T, error)
+
+	mu     sync.Mutex
+	loaded bool
+	sum    [sha256.Size]byte
+	value  T
+}
+
+func newReloadingFile[T any](path string, parse func([]byte) (T, error)) (*reloadingFile[T], error) {
+	f := &reloadingFile[T]{path: path, parse: parse}
+	if _, err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load returns the current value. If re-reading a changed file fails, the last
+// good value is kept so a half-written file doesn't lock everybody out.
+func (f *reloadingFile[T]) load() (T, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return f.value, err
+	}
+	sum := sha256.Sum256(content)
+	if f.loaded && sum == f.sum {
+		return f.value, nil
+	}
+	value, err := f.parse(content)
+	if err != nil {
+		return f.value, fmt.Errorf("failed to parse %s: %w", f.path, err)
+	}
+
+	if f.loaded {
+		log.Info().Msgf("Reloaded %s", f.path)
+	}
+	f.value = value
+	f.sum = sum
+	f.loaded = true
+	return value, nil
+}
+
+type bearerTokenBackend struct {
+	tokens []string
+	file   *reloadingFile[[]string]
+}
+
+// NewBearerTokenBackend accepts "Authorization: Bearer <token>" requests. The
+// tokens are taken from the static list and, if tokenFile is set, from that
+// file (one token per line), which is re-read when it changes.
+func NewBearerTokenBackend(tokens []string, tokenFile string) (AuthBackend, error) {
+	b := &bearerTokenBackend{}
+	for _, t := range tokens {
+		if t = strings.TrimSpace(t); t != "" {
+			b.tokens = append(b.tokens, t)
+		}
+	}
+
+	if tokenFile != "" {
+		file, err := newReloadingFile(tokenFile, parseTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		b.file = file
+	}
+
+	if len(b.tokens) == 0 && b.file == nil {
+		return nil, fmt.Errorf("bearer token auth needs at least one token or a token file")
+	}
+	return b, nil
+}
+
+func parseTokenFile(content []byte) ([]string, error) {
+	var tokens []string
+	for line := range strings.SplitSeq(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("token file is empty")
+	}
+	return tokens, nil
+}
+
+func (b *bearerTokenBackend) Authenticate(r *http.Request) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	given = strings.TrimSpace(given)
+
+	tokens := b.tokens
+	if b.file != nil {
+		fileTokens, err := b.file.load()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to reload bearer token file, using last known tokens")
+		}
+		tokens = append(tokens[:len(tokens):len(tokens)], fileTokens...)
+	}
+
+	// compare against every token so the position of a match doesn't leak
+	match := false
+	for _, t := range tokens {
+		if secureCompare(given, t) {
+			match = true
+		}
+	}
+	return match
+}
+
+func (b *bearerTokenBackend) Challenge() string {
+	return `Bearer realm="restricted"`
+}
+
+type htpasswdBackend struct {
+	file *reloadingFile[map[string]string]
+}
+
+// NewHtpasswdBackend checks basic auth credentials against an Apache htpasswd
+// file. Supported hash formats are bcrypt ($2y$, $2a$, $2b$) and {SHA}. The
+// file is re-read when it changes.
+func NewHtpasswdBackend(path string) (AuthBackend, error) {
+	file, err := newReloadingFile(path, parseHtpasswd)
+	if err != nil {
+		return nil, err
+	}
+	return &htpasswdBackend{file: file}, nil
+}
+
+func parseHtpasswd(content []byte) (map[string]string, error) {
+	users := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" || hash == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", lineNo)
+		}
+		if !isSupportedHtpasswdHash(hash) {
+			log.Warn().Msgf("Ignoring htpasswd user %q with unsupported hash format (use bcrypt or {SHA})", user)
+			continue
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func isSupportedHtpasswdHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") ||
+		strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "{SHA}")
+}
+
+func (b *htpasswdBackend) Authenticate(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	users, err := b.file.load()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to reload htpasswd file, using last known users")
+	}
+
+	hash, ok := users[username]
+	if !ok {
+		return false
+	}
+
+	if sha, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		sum := sha1.Sum([]byte(password)) //nolint:gosec // see import
+		return secureCompare(base64.StdEncoding.EncodeToString(sum[:]), sha)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (b *htpasswdBackend) Challenge() string {
+	return `Basic realm="restricted", charset="UTF-8"`
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func doRequest(h http.Handler, path string, setup func(r *http.Request)) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if setup != nil {
+		setup(req)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAuthChain_BearerTokenFileReload(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := NewBearerTokenBackend([]string{"static"}, tokenFile)
+	if err != nil {
+		t.Fatalf("expected backend setup to succeed, got error: %v", err)
+	}
+	h := NewAuthChain(AuthChainOptions{}, backend).Middleware(okHandler)
+
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	if code := doRequest(h, "/metrics", bearer("static")); code != http.StatusOK {
+		t.Errorf("expected static token to be accepted, got %d", code)
+	}
+	if code := doRequest(h, "/metrics", bearer("first")); code != http.StatusOK {
+		t.Errorf("expected file token to be accepted, got %d", code)
+	}
+
+	if err := os.WriteFile(tokenFile, []byte("second-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code := doRequest(h, "/metrics", bearer("first")); code != http.StatusUnauthorized {
+		t.Errorf("expected rotated token to be rejected, got %d", code)
+	}
+	if code := doRequest(h, "/metrics", bearer("second-token")); code != http.StatusOK {
+		t.Errorf("expected new file token to be accepted, got %d", code)
+	}
+}
+
+func TestAuthChain_TokenFileRotationWithSameSizeAndModTime(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token-aaaa\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := NewBearerTokenBackend(nil, tokenFile)
+	if err != nil {
+		t.Fatalf("expected backend setup to succeed, got error: %v", err)
+	}
+	h := NewAuthChain(AuthChainOptions{}, backend).Middleware(okHandler)
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	// a rotation within the mtime granularity of the filesystem
+	if err := os.WriteFile(tokenFile, []byte("token-bbbb\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(tokenFile, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if code := doRequest(h, "/metrics", bearer("token-aaaa")); code != http.StatusUnauthorized {
+		t.Errorf("expected rotated token to be rejected, got %d", code)
+	}
+	if code := doRequest(h, "/metrics", bearer("token-bbbb")); code != http.StatusOK {
+		t.Errorf("expected new file token to be accepted, got %d", code)
+	}
+}
+
+func TestAuthChain_Htpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "# comment\nalice:" + string(hash) + "\n" +
+		// "password" in htpasswd -s format
+		"bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n" +
+		"carol:$apr1$unsupported\n"
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := NewHtpasswdBackend(htpasswd)
+	if err != nil {
+		t.Fatalf("expected backend setup to succeed, got error: %v", err)
+	}
+	h := NewAuthChain(AuthChainOptions{}, backend).Middleware(okHandler)
+
+	tests := []struct {
+		user, password string
+		want           int
+	}{
+		{"alice", "secret", http.StatusOK},
+		{"alice", "wrong", http.StatusUnauthorized},
+		{"bob", "password", http.StatusOK},
+		{"carol", "anything", http.StatusUnauthorized},
+		{"dave", "secret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		code := doRequest(h, "/metrics", func(r *http.Request) { r.SetBasicAuth(tt.user, tt.password) })
+		if code != tt.want {
+			t.Errorf("%s/%s: expected %d, got %d", tt.user, tt.password, tt.want, code)
+		}
+	}
+}
+
+func TestAuthChain_ExemptPaths(t *testing.T) {
+	chain := NewAuthChain(AuthChainOptions{ExemptPaths: []string{"/healthz", "/static/"}},
+		NewBasicAuthBackend("user", "pass"))
+	h := chain.Middleware(okHandler)
+
+	for path, want := range map[string]int{
+		"/healthz":      http.StatusOK,
+		"/static/x.css": http.StatusOK,
+		"/healthz2":     http.StatusUnauthorized,
+		"/metrics":      http.StatusUnauthorized,
+	} {
+		if code := doRequest(h, path, nil); code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, code)
+		}
+	}
+}
+
+func TestAuthChain_RateLimit(t *testing.T) {
+	chain := NewAuthChain(AuthChainOptions{MaxFailures: 2, FailureWindow: time.Minute},
+		NewBasicAuthBackend("user", "pass"))
+	now := time.Now()
+	chain.limiter.now = func() time.Time { return now }
+	h := chain.Middleware(okHandler)
+
+	wrong := func(r *http.Request) { r.SetBasicAuth("user", "wrong") }
+	right := func(r *http.Request) { r.SetBasicAuth("user", "pass") }
+
+	for range 2 {
+		if code := doRequest(h, "/metrics", wrong); code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", code)
+		}
+	}
+	if code := doRequest(h, "/metrics", right); code != http.StatusTooManyRequests {
+		t.Errorf("expected client to be rate limited, got %d", code)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if code := doRequest(h, "/metrics", right); code != http.StatusOK {
+		t.Errorf("expected client to be allowed after window, got %d", code)
+	}
+}
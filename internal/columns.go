@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ColumnInfo describes a turbostat output column.
+type ColumnInfo struct {
+	Name string
+	Unit string
+	Help string
+	// Module groups the column for collect[] of /metrics, see ColumnModule.
+	Module string
+}
+
+const (
+	UnitMHz     = "MHz"
+	UnitPercent = "percent"
+	UnitWatts   = "watts"
+	UnitJoules  = "joules"
+	UnitCelsius = "celsius"
+	UnitCount   = "count"
+	UnitRatio   = "ratio"
+	UnitSeconds = "seconds"
+	UnitMicros  = "microseconds"
+)
+
+const (
+	ModuleFrequency   = "frequency"
+	ModuleUtilization = "utilization"
+	ModuleCStates     = "cstates"
+	ModulePower       = "power"
+	ModuleTemperature = "temperature"
+	ModuleOther       = "other"
+)
+
+// Modules are the column groups scrapers can select with collect[].
+var Modules = []string{ModuleFrequency, ModuleUtilization, ModuleCStates, ModulePower, ModuleTemperature, ModuleOther}
+
+// knownColumns documents the fixed columns printed by turbostat (see
+// turbostat(8)). Columns with variable names like C-states are matched by
+// columnPatterns instead.
+var knownColumns = map[string]ColumnInfo{
+	"Avg_MHz":             {Unit: UnitMHz, Help: "Average frequency over the whole interval, including idle time.", Module: ModuleFrequency},
+	"Busy%":               {Unit: UnitPercent, Help: "Percentage of time in C0 (not idle).", Module: ModuleUtilization},
+	"Bzy_MHz":             {Unit: UnitMHz, Help: "Average frequency while in C0.", Module: ModuleFrequency},
+	"TSC_MHz":             {Unit: UnitMHz, Help: "Average frequency of the time stamp counter.", Module: ModuleFrequency},
+	"IPC":                 {Unit: UnitRatio, Help: "Instructions retired per cycle.", Module: ModuleUtilization},
+	"IRQ":                 {Unit: UnitCount, Help: "Number of interrupts serviced during the interval.", Module: ModuleUtilization},
+	"SMI":                 {Unit: UnitCount, Help: "Number of system management interrupts during the interval.", Module: ModuleUtilization},
+	"CoreTmp":             {Unit: UnitCelsius, Help: "Core temperature.", Module: ModuleTemperature},
+	"CoreThr":             {Unit: UnitCount, Help: "Core thermal throttling events during the interval.", Module: ModuleTemperature},
+	"PkgTmp":              {Unit: UnitCelsius, Help: "Package temperature.", Module: ModuleTemperature},
+	"GFX%rc6":             {Unit: UnitPercent, Help: "Percentage of time the GPU is in render C6.", Module: ModuleCStates},
+	"GFXMHz":              {Unit: UnitMHz, Help: "GPU frequency.", Module: ModuleFrequency},
+	"GFXAMHz":             {Unit: UnitMHz, Help: "GPU actual frequency.", Module: ModuleFrequency},
+	"Totl%C0":             {Unit: UnitPercent, Help: "Sum of C0 residency of all CPUs in the package.", Module: ModuleCStates},
+	"Any%C0":              {Unit: UnitPercent, Help: "Percentage of time any CPU in the package is in C0.", Module: ModuleCStates},
+	"GFX%C0":              {Unit: UnitPercent, Help: "Percentage of time the GPU is busy.", Module: ModuleCStates},
+	"CPUGFX%":             {Unit: UnitPercent, Help: "Percentage of time a CPU and the GPU are busy at the same time.", Module: ModuleCStates},
+	"CPU%LPI":             {Unit: UnitPercent, Help: "Percentage of time in low power idle.", Module: ModuleCStates},
+	"SYS%LPI":             {Unit: UnitPercent, Help: "Percentage of time the system is in low power idle.", Module: ModuleCStates},
+	"PkgWatt":             {Unit: UnitWatts, Help: "Package power consumption.", Module: ModulePower},
+	"CorWatt":             {Unit: UnitWatts, Help: "Core power consumption.", Module: ModulePower},
+	"GFXWatt":             {Unit: UnitWatts, Help: "GPU power consumption.", Module: ModulePower},
+	"RAMWatt":             {Unit: UnitWatts, Help: "DRAM power consumption.", Module: ModulePower},
+	"Pkg_J":               {Unit: UnitJoules, Help: "Package energy consumed during the interval.", Module: ModulePower},
+	"Cor_J":               {Unit: UnitJoules, Help: "Core energy consumed during the interval.", Module: ModulePower},
+	"GFX_J":               {Unit: UnitJoules, Help: "GPU energy consumed during the interval.", Module: ModulePower},
+	"RAM_J":               {Unit: UnitJoules, Help: "DRAM energy consumed during the interval.", Module: ModulePower},
+	"PKG_%":               {Unit: UnitPercent, Help: "Percentage of the interval the package was power limited by RAPL.", Module: ModulePower},
+	"RAM_%":               {Unit: UnitPercent, Help: "Percentage of the interval DRAM was power limited by RAPL.", Module: ModulePower},
+	"UncMHz":              {Unit: UnitMHz, Help: "Uncore frequency.", Module: ModuleFrequency},
+	"Time_Of_Day_Seconds": {Unit: UnitSeconds, Help: "Time of day of the sample.", Module: ModuleOther},
+	"Usec":                {Unit: UnitMicros, Help: "Microseconds needed to collect the counters of this CPU.", Module: ModuleOther},
+}
+
+type columnPattern struct {
+	re   *regexp.Regexp
+	info ColumnInfo
+}
+
+var columnPatterns = []columnPattern{
+	{regexp.MustCompile(`^CPU%c\d+$`), ColumnInfo{Unit: UnitPercent, Help: "Percentage of time the CPU is in the hardware core C-state.", Module: ModuleCStates}},
+	{regexp.MustCompile(`^Pkg?%pc\d+$`), ColumnInfo{Unit: UnitPercent, Help: "Percentage of time the package is in the package C-state.", Module: ModuleCStates}},
+	{regexp.MustCompile(`^(POLL|C\d+\w*)%$`), ColumnInfo{Unit: UnitPercent, Help: "Percentage of time in the software C-state requested by the OS.", Module: ModuleCStates}},
+	{regexp.MustCompile(`^(POLL|C\d+\w*)$`), ColumnInfo{Unit: UnitCount, Help: "Number of times the software C-state was requested by the OS.", Module: ModuleCStates}},
+}
+
+// LookupColumn returns the description of a turbostat column. Unknown
+// columns get a best effort unit derived from their name.
+func LookupColumn(name string) ColumnInfo {
+	if info, ok := knownColumns[name]; ok {
+		info.Name = name
+		return info
+	}
+	for _, p := range columnPatterns {
+		if p.re.MatchString(name) {
+			info := p.info
+			info.Name = name
+			return info
+		}
+	}
+
+	info := ColumnInfo{Name: name}
+	switch {
+	case strings.Contains(name, "%"):
+		info.Unit = UnitPercent
+	case strings.HasSuffix(name, "MHz"):
+		info.Unit = UnitMHz
+	case strings.HasSuffix(name, "Watt"):
+		info.Unit = UnitWatts
+	case strings.HasSuffix(name, "_J"):
+		info.Unit = UnitJoules
+	}
+	return info
+}
+
+// ColumnModule returns the module of a column. Columns without one in the
+// catalog, e.g. custom counters, are grouped by their unit.
+func ColumnModule(name string) string {
+	info := LookupColumn(name)
+	if info.Module != "" {
+		return info.Module
+	}
+	switch info.Unit {
+	case UnitMHz:
+		return ModuleFrequency
+	case UnitWatts, UnitJoules:
+		return ModulePower
+	case UnitCelsius:
+		return ModuleTemperature
+	}
+	return ModuleOther
+}
+
+// RegisterColumn adds or replaces an entry of the column catalog. It must
+// only be called during startup.
+func RegisterColumn(info ColumnInfo) {
+	knownColumns[info.Name] = info
+}
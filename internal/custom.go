@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// CustomCounter declares an additional MSR or perf counter that is collected
+// with turbostat --add and exported as its own metric.
+type CustomCounter struct {
+	// MSR is the register address, e.g. "0x34". Exactly one of MSR and
+	// PerfEvent must be set.
+	MSR string `json:"msr"`
+	// PerfEvent is a perf event as "<device>/<event>", e.g. "cstate_core/c1-residency".
+	PerfEvent string `json:"perf_event"`
+	// Width is "u32" or "u64" and only applies to MSRs (default u64).
+	Width string `json:"width"`
+	// Scope is "cpu", "core" or "package".
+	Scope string `json:"scope"`
+	// Format is "raw", "delta" or "percent".
+	Format string `json:"format"`
+	// Column is the turbostat column name, at most 16 characters.
+	Column string `json:"column"`
+	// Metric is the metric name without the "turbostat_" prefix.
+	Metric string `json:"metric"`
+	Unit   string `json:"unit"`
+	Help   string `json:"help"`
+}
+
+var (
+	msrAddressPattern   = regexp.MustCompile(`^(0x[0-9a-fA-F]+|[0-9]+)$`)
+	perfEventPattern    = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+	customColumnPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,15}$`)
+	metricNamePattern   = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// reservedMetricNames are the built-in metrics without the "turbostat_"
+// prefix. Names starting with one of them and "_" are reserved as well, they
+// are used by the percent families, aggregates and CPU histograms, e.g.
+// turbostat_cpus_busy_percent.
+var reservedMetricNames = []string{"total", "packages", "cores", "cpus", "exporter", "ingest", "series_limit_exceeded"}
+
+func reservedMetricName(name string) bool {
+	for _, r := range reservedMetricNames {
+		if name == r || strings.HasPrefix(name, r+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadCustomCounters reads a JSON array of CustomCounter from path and
+// validates every entry.
+func LoadCustomCounters(path string) ([]CustomCounter, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var counters []CustomCounter
+	if err := json.Unmarshal(content, &counters); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i := range counters {
+		c := &counters[i]
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("custom counter %d (%s): %w", i, c.Column, err)
+		}
+		if seen[c.Column] || seen["metric:"+c.Metric] {
+			return nil, fmt.Errorf("custom counter %d: duplicate column or metric name %q", i, c.Column)
+		}
+		seen[c.Column] = true
+		seen["metric:"+c.Metric] = true
+	}
+	return counters, nil
+}
+
+func (c *CustomCounter) validate() error {
+	switch {
+	case c.MSR != "" && c.PerfEvent != "":
+		return fmt.Errorf("set either msr or perf_event, not both")
+	case c.MSR != "":
+		if !msrAddressPattern.MatchString(c.MSR) {
+			return fmt.Errorf("invalid msr address %q", c.MSR)
+		}
+		if c.Width == "" {
+			c.Width = "u64"
+		}
+		if c.Width != "u32" && c.Width != "u64" {
+			return fmt.Errorf("width must be u32 or u64")
+		}
+	case c.PerfEvent != "":
+		if !perfEventPattern.MatchString(c.PerfEvent) {
+			return fmt.Errorf("invalid perf event %q", c.PerfEvent)
+		}
+		if c.Width != "" {
+			return fmt.Errorf("width only applies to msr counters")
+		}
+	default:
+		return fmt.Errorf("msr or perf_event is required")
+	}
+
+	if !slices.Contains([]string{"cpu", "core", "package"}, c.Scope) {
+		return fmt.Errorf("scope must be cpu, core or package")
+	}
+	if c.Format == "" {
+		c.Format = "delta"
+	}
+	if !slices.Contains([]string{"raw", "delta", "percent"}, c.Format) {
+		return fmt.Errorf("format must be raw, delta or percent")
+	}
+	if !customColumnPattern.MatchString(c.Column) {
+		return fmt.Errorf("column must be 1-16 letters, digits or underscores")
+	}
+	if c.Metric == "" {
+		c.Metric = strings.ToLower(c.Column)
+	}
+	if !metricNamePattern.MatchString(c.Metric) {
+		return fmt.Errorf("invalid metric name %q", c.Metric)
+	}
+	if reservedMetricName(c.Metric) {
+		return fmt.Errorf("metric name %q collides with a metric of the exporter, set a different metric", c.Metric)
+	}
+	if c.Help == "" {
+		c.Help = fmt.Sprintf("Custom turbostat counter %s.", c.Column)
+	}
+	return nil
+}
+
+// AddArg returns the value for turbostat --add.
+func (c CustomCounter) AddArg() string {
+	parts := []string{}
+	if c.MSR != "" {
+		parts = append(parts, "msr"+c.MSR, c.Width)
+	} else {
+		parts = append(parts, "perf/"+c.PerfEvent)
+	}
+	parts = append(parts, c.Scope, c.Format, c.Column)
+	return strings.Join(parts, ",")
+}
+
+// ColumnInfo describes the counter for the column catalog.
+func (c CustomCounter) ColumnInfo() ColumnInfo {
+	return ColumnInfo{Name: c.Column, Unit: c.Unit, Help: c.Help}
+}
+
+// KeepCustomColumns wraps a column filter so the columns of counters always
+// pass. They are requested with --add and never filtered by --show/--hide.
+func KeepCustomColumns(counters []CustomCounter, allowed func(string) bool) func(string) bool {
+	return func(header string) bool {
+		for _, c := range counters {
+			if c.Column == header {
+				return true
+			}
+		}
+		return allowed(header)
+	}
+}
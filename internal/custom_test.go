@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadCustomCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.json")
+	content := `[
+  {"msr": "0x34", "width": "u32", "scope": "package", "format": "delta", "column": "SMIcount", "metric": "smi_count", "unit": "count", "help": "SMIs during the interval."},
+  {"perf_event": "cstate_core/c1-residency", "scope": "core", "format": "percent", "column": "C1res"}
+]`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	counters, err := LoadCustomCounters(path)
+	if err != nil {
+		t.Fatalf("expected counters to load, got error: %v", err)
+	}
+	if got := counters[0].AddArg(); got != "msr0x34,u32,package,delta,SMIcount" {
+		t.Errorf("unexpected --add argument %q", got)
+	}
+	if got := counters[1].AddArg(); got != "perf/cstate_core/c1-residency,core,percent,C1res" {
+		t.Errorf("unexpected --add argument %q", got)
+	}
+	if counters[1].Metric != "c1res" {
+		t.Errorf("expected metric name to default to the column, got %q", counters[1].Metric)
+	}
+
+	for _, invalid := range []string{
+		`[{"msr": "0x34", "scope": "thread", "column": "X"}]`,
+		`[{"msr": "0x34", "perf_event": "a/b", "scope": "cpu", "column": "X"}]`,
+		`[{"perf_event": "a/b,c", "scope": "cpu", "column": "X"}]`,
+		`[{"msr": "0x34", "scope": "cpu", "column": "Way_too_long_column"}]`,
+		`[{"msr": "0x34", "scope": "cpu", "column": "X"}, {"msr": "0x35", "scope": "cpu", "column": "X"}]`,
+		`[{"msr": "0x34", "scope": "cpu", "column": "X", "metric": "packages"}]`,
+		`[{"msr": "0x34", "scope": "cpu", "column": "X", "metric": "cores_percent"}]`,
+		`[{"msr": "0x34", "scope": "cpu", "column": "X", "metric": "series_limit_exceeded"}]`,
+		`[{"msr": "0x34", "scope": "cpu", "column": "X", "metric": "exporter_suppressed_series"}]`,
+		`[{"msr": "0x34", "scope": "cpu", "column": "X", "metric": "cpus_busy_percent"}]`,
+		`[{"msr": "0x34", "scope": "cpu", "column": "Total"}]`,
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadCustomCounters(path); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestExporter_CustomCounters(t *testing.T) {
+	headers := []string{"Package", "Core", "CPU", "Busy%", "CoreTmp", "SMIcount"}
+	rows := [][]string{
+		{"-", "-", "-", "2.00", "45", "7"},
+		{"0", "0", "0", "1.00", "40", "3"},
+		{"0", "0", "4", "1.50"},
+		{"0", "1", "1", "2.00", "41"},
+		{"1", "0", "2", "3.00", "50", "4"},
+		{"1", "0", "6", "3.50"},
+		{"1", "1", "3", "4.00", "51"},
+	}
+	parsed := NewTurbostatParser().ParseRowsSimple(headers, rows)
+
+	registry := prometheus.NewRegistry()
+	exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+	exporter.AddCustomCounters(prefixed(registry), []CustomCounter{
+		{MSR: "0x34", Width: "u32", Scope: "package", Format: "delta", Column: "SMIcount", Metric: "smi_count", Help: "SMIs."},
+	})
+	exporter.Update(FlattenRows(parsed))
+
+	expected := `
+# HELP turbostat_smi_count SMIs.
+# TYPE turbostat_smi_count gauge
+turbostat_smi_count{package="0"} 3
+turbostat_smi_count{package="1"} 4
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "turbostat_smi_count"); err != nil {
+		t.Error(err)
+	}
+
+	// coretmp stays a generic metric, smicount must not show up there
+	if n := testutil.CollectAndCount(exporter.packages); n != 2 {
+		t.Errorf("expected only coretmp as generic package metric, got %d series", n)
+	}
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// DashboardHandler serves the live dashboard. It is a single page without
+// external assets, so it works on hosts without internet access.
+func DashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(dashboardPage)
+	})
+}
+
+// SnapshotBroadcaster passes every collection to the connected stream
+// clients. Slow clients skip collections instead of delaying others.
+type SnapshotBroadcaster struct {
+	maxClients int
+
+	mu      sync.Mutex
+	latest  *Snapshot
+	clients map[chan *Snapshot]struct{}
+	closed  bool
+}
+
+func NewSnapshotBroadcaster(maxClients int) *SnapshotBroadcaster {
+	return &SnapshotBroadcaster{maxClients: maxClients, clients: map[chan *Snapshot]struct{}{}}
+}
+
+// Publish sends snap to all clients.
+func (b *SnapshotBroadcaster) Publish(snap *Snapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latest = snap
+	for c := range b.clients {
+		// replace a snapshot the client hasn't picked up yet
+		select {
+		case <-c:
+		default:
+		}
+		c <- snap
+	}
+}
+
+// subscribe returns a channel receiving the latest and all following
+// snapshots, or nil if there are too many clients.
+func (b *SnapshotBroadcaster) subscribe() chan *Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || len(b.clients) >= b.maxClients {
+		return nil
+	}
+	c := make(chan *Snapshot, 1)
+	if b.latest != nil {
+		c <- b.latest
+	}
+	b.clients[c] = struct{}{}
+	return c
+}
+
+func (b *SnapshotBroadcaster) unsubscribe(c chan *Snapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c)
+	}
+}
+
+// Close disconnects all clients, the server waits for open streams on
+// shutdown otherwise.
+func (b *SnapshotBroadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for c := range b.clients {
+		delete(b.clients, c)
+		close(c)
+	}
+}
+
+// streamKeepAlive is the interval of comments keeping idle streams open
+// through proxies.
+const streamKeepAlive = 15 * time.Second
+
+// StreamHandler sends every collection as Server-Sent Event "snapshot" with
+// the same JSON as /api/v1/snapshot. The filter parameters of the snapshot
+// endpoint apply as well.
+func StreamHandler(b *SnapshotBroadcaster) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter, err := ParseSnapshotFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c := b.subscribe()
+		if c == nil {
+			http.Error(w, "too many stream clients", http.StatusServiceUnavailable)
+			return
+		}
+		defer b.unsubscribe(c)
+
+		// the write timeout of the server is meant for scrapes, a stream
+		// stays open until the client leaves
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Debug().Err(err).Msg("Failed to clear the write deadline of a stream")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case snap, ok := <-c:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(newSnapshotResponse(snap, filter))
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to encode snapshot for stream")
+					return
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: snapshot\ndata: %s\n\n", snap.Timestamp.UnixMilli(), data); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent returns the data of the next event of an SSE stream.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" && data != "" {
+			return data
+		}
+		if v, ok := strings.CutPrefix(line, "data: "); ok {
+			data = v
+		}
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	broadcaster := NewSnapshotBroadcaster(1)
+	broadcaster.Publish(&Snapshot{Timestamp: time.Now(), Rows: []TurbostatRow{
+		{Category: "cpu", CPU: "0", Other: map[string]float64{"Bzy_MHz": 800}, OtherPercent: map[string]float64{}},
+	}})
+	server := httptest.NewServer(StreamHandler(broadcaster))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?category=cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	events := bufio.NewReader(resp.Body)
+
+	// the latest collection is sent right away, following ones as they come
+	var first, second snapshotResponse
+	if err := json.Unmarshal([]byte(readEvent(t, events)), &first); err != nil || first.Rows[0].Values["Bzy_MHz"] != 800 {
+		t.Errorf("expected the latest snapshot, got %+v (%v)", first, err)
+	}
+	broadcaster.Publish(&Snapshot{Timestamp: time.Now(), Rows: []TurbostatRow{
+		{Category: "total", Other: map[string]float64{"Bzy_MHz": 900}, OtherPercent: map[string]float64{}},
+		{Category: "cpu", CPU: "0", Other: map[string]float64{"Bzy_MHz": 3000}, OtherPercent: map[string]float64{}},
+	}})
+	if err := json.Unmarshal([]byte(readEvent(t, events)), &second); err != nil || len(second.Rows) != 1 || second.Rows[0].Values["Bzy_MHz"] != 3000 {
+		t.Errorf("expected the filtered new snapshot, got %+v (%v)", second, err)
+	}
+
+	busy, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy.Body.Close()
+	if busy.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 above the client limit, got %d", busy.StatusCode)
+	}
+
+	broadcaster.Close()
+	if _, err := events.ReadString('\n'); err == nil {
+		t.Error("expected the stream to end on close")
+	}
+}
@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DebugCapture holds the raw turbostat output of one collection together with
+// the decisions the parser made on it.
+type DebugCapture struct {
+	Timestamp time.Time
+	Command   string
+	Stdout    string
+	Stderr    string
+	// Combined is stdout and stderr interleaved as the parser sees it.
+	Combined string
+	Headers  []string
+	Warnings []string
+	// RowCategories maps the row lengths to the category from ParseCategories.
+	RowCategories  map[int]string
+	CategoryCounts map[string]int
+	// UnparsedColumns are headers without a value in any parsed row.
+	UnparsedColumns []string
+	// FilteredColumns are headers dropped on purpose by the column filter,
+	// e.g. TURBOSTAT_SHOW/TURBOSTAT_HIDE.
+	FilteredColumns []string
+	// DuplicateColumns are headers which occur more than once or export as
+	// the same metric type as another header.
+	DuplicateColumns []string
+	Error            string
+}
+
+// AnalyzeRows fills RowCategories and CategoryCounts and adds a warning for
+// every row length the parser could not map to a category.
+func (c *DebugCapture) AnalyzeRows(rowCategories map[int]string, rows [][]string, parsed map[string][]*TurbostatRow) {
+	c.RowCategories = maps.Clone(rowCategories)
+	c.CategoryCounts = map[string]int{}
+	for category, r := range parsed {
+		c.CategoryCounts[category] = len(r)
+	}
+
+	unmapped := map[int]int{}
+	for _, row := range rows {
+		if len(row) > 0 && row[0] == "-" {
+			continue
+		}
+		if _, ok := rowCategories[len(row)]; !ok {
+			unmapped[len(row)]++
+		}
+	}
+	for _, length := range slices.Sorted(maps.Keys(unmapped)) {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("%d rows with %d columns did not match any category", unmapped[length], length))
+	}
+}
+
+// AnalyzeColumns fills UnparsedColumns, FilteredColumns and
+// DuplicateColumns. allowed is the column filter of the parser, nil if it
+// keeps every column.
+func (c *DebugCapture) AnalyzeColumns(rows []TurbostatRow, allowed func(header string) bool) {
+	c.UnparsedColumns = nil
+	c.FilteredColumns = nil
+	c.DuplicateColumns = nil
+
+	parsed := map[string]bool{}
+	for _, row := range rows {
+		for column := range row.Other {
+			parsed[column] = true
+		}
+		for column := range row.OtherPercent {
+			parsed[column] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	types := map[string]string{}
+	for _, h := range c.Headers {
+		if h == "Package" || h == "Core" || h == "CPU" {
+			continue
+		}
+		if seen[h] {
+			c.DuplicateColumns = append(c.DuplicateColumns, h)
+			continue
+		}
+		seen[h] = true
+
+		// percent and other columns are exported as different metrics
+		key := fmt.Sprintf("%s/%t", sanitizeHeader(h), strings.Contains(h, "%"))
+		if other, ok := types[key]; ok {
+			c.DuplicateColumns = append(c.DuplicateColumns, fmt.Sprintf("%s (same metric type as %s)", h, other))
+		} else {
+			types[key] = h
+		}
+
+		switch {
+		case parsed[h]:
+		case allowed != nil && !allowed(h):
+			c.FilteredColumns = append(c.FilteredColumns, h)
+		default:
+			c.UnparsedColumns = append(c.UnparsedColumns, h)
+		}
+	}
+}
+
+// WriteReport writes a plain text summary of the capture, the counterpart of
+// the debug page for the command line.
+func (c *DebugCapture) WriteReport(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Source: %s\n", c.Command)
+	if c.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", c.Error)
+	}
+
+	fmt.Fprintf(&b, "\nHeaders (%d):\n", len(c.Headers))
+	for i, h := range c.Headers {
+		fmt.Fprintf(&b, "  %3d: %s\n", i, h)
+	}
+
+	b.WriteString("\nRow length to category:\n")
+	for _, length := range slices.Sorted(maps.Keys(c.RowCategories)) {
+		fmt.Fprintf(&b, "  %3d columns: %s\n", length, c.RowCategories[length])
+	}
+
+	b.WriteString("\nRows per category:\n")
+	for _, category := range []string{"total", "package", "core", "cpu"} {
+		fmt.Fprintf(&b, "  %-8s %d\n", category, c.CategoryCounts[category])
+	}
+
+	writeList := func(title string, items []string) {
+		fmt.Fprintf(&b, "\n%s:", title)
+		if len(items) == 0 {
+			b.WriteString(" none\n")
+			return
+		}
+		b.WriteString("\n")
+		for _, item := range items {
+			fmt.Fprintf(&b, "  ! %s\n", item)
+		}
+	}
+	writeList("Warnings", c.Warnings)
+	writeList("Columns without parsed values", c.UnparsedColumns)
+	writeList("Duplicate columns", c.DuplicateColumns)
+
+	b.WriteString("\nColumns dropped by the column filter:")
+	if len(c.FilteredColumns) == 0 {
+		b.WriteString(" none\n")
+	} else {
+		fmt.Fprintf(&b, " %s\n", strings.Join(c.FilteredColumns, ", "))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// DebugStore keeps the latest DebugCapture. It is safe for concurrent use.
+type DebugStore struct {
+	mu      sync.RWMutex
+	capture *DebugCapture
+}
+
+func NewDebugStore() *DebugStore {
+	return &DebugStore{}
+}
+
+func (s *DebugStore) Set(capture *DebugCapture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capture = capture
+}
+
+func (s *DebugStore) Latest() *DebugCapture {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.capture
+}
+
+var debugPageTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Turbostat Exporter - Debug</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+td, th { text-align: left; padding: 0.2em 1em 0.2em 0; }
+.error { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>Last turbostat capture</h1>
+<p>Captured {{.Timestamp.Format "2006-01-02T15:04:05Z07:00"}} running <code>{{.Command}}</code>.
+<a href="?download=1">Download capture as fixture</a></p>
+{{if .Error}}<p class="error">Error: {{.Error}}</p>{{end}}
+<h2>Warnings</h2>
+{{if .Warnings}}<ul>{{range .Warnings}}<li>{{.}}</li>{{end}}</ul>{{else}}<p>none</p>{{end}}
+{{if .UnparsedColumns}}<p class="error">Columns without parsed values: {{range $i, $c := .UnparsedColumns}}{{if $i}}, {{end}}{{$c}}{{end}}</p>{{end}}
+{{if .DuplicateColumns}}<p class="error">Duplicate columns: {{range $i, $c := .DuplicateColumns}}{{if $i}}, {{end}}{{$c}}{{end}}</p>{{end}}
+{{if .FilteredColumns}}<p>Columns dropped by the column filter: {{range $i, $c := .FilteredColumns}}{{if $i}}, {{end}}{{$c}}{{end}}</p>{{end}}
+<h2>Headers ({{len .Headers}})</h2>
+<pre>{{range $i, $h := .Headers}}{{$i}}: {{$h}}
+{{end}}</pre>
+<h2>Row length to category</h2>
+<table>
+<tr><th>Columns</th><th>Category</th></tr>
+{{range $l, $c := .RowCategories}}<tr><td>{{$l}}</td><td>{{$c}}</td></tr>
+{{end}}</table>
+<h2>Rows per category</h2>
+<table>
+{{range $c, $n := .CategoryCounts}}<tr><th>{{$c}}</th><td>{{$n}}</td></tr>
+{{end}}</table>
+<h2>stdout</h2>
+<pre>{{.Stdout}}</pre>
+<h2>stderr</h2>
+<pre>{{.Stderr}}</pre>
+</body>
+</html>
+`))
+
+// DebugHandler shows the last raw turbostat output and how it was parsed.
+// With ?download=1 the combined output is returned as a file that can be used
+// as a test fixture like the ones in data/.
+func DebugHandler(store *DebugStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capture := store.Latest()
+		if capture == nil {
+			http.Error(w, "no collection finished yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.URL.Query().Get("download") != "" {
+			filename := fmt.Sprintf("turbostat-%s.tsv", capture.Timestamp.UTC().Format("20060102-150405"))
+			w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+			if _, err := w.Write([]byte(capture.Combined)); err != nil {
+				log.Error().Err(err).Msg("Failed to write debug capture")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := debugPageTemplate.Execute(w, capture); err != nil {
+			log.Error().Err(err).Msg("Failed to render debug page")
+		}
+	})
+}
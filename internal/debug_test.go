@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugCapture_AnalyzeRows(t *testing.T) {
+	headers := []string{"Core", "CPU", "Busy%"}
+	rows := [][]string{
+		{"-", "-", "1.00"},
+		{"0", "0", "1.00"},
+		{"0", "1", "1.00"},
+	}
+	parser := NewTurbostatParser()
+	categories := parser.ParseCategories(headers, rows)
+	parsed := parser.ParseRowsSimple(headers, rows)
+
+	capture := &DebugCapture{Headers: headers}
+	capture.AnalyzeRows(categories, append(rows, []string{"garbage"}), parsed)
+
+	if capture.CategoryCounts["cpu"] != 2 {
+		t.Errorf("expected 2 cpu rows, got %v", capture.CategoryCounts)
+	}
+	if len(capture.Warnings) != 1 || !strings.Contains(capture.Warnings[0], "1 rows with 1 columns") {
+		t.Errorf("expected one warning about the unmapped row, got %v", capture.Warnings)
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	store := NewDebugStore()
+	h := DebugHandler(store)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/turbostat", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before the first collection, got %d", rec.Code)
+	}
+
+	store.Set(&DebugCapture{
+		Timestamp: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		Command:   "turbostat --quiet sleep 5",
+		Stdout:    "Core\tCPU\tBusy%\n",
+		Combined:  "Core\tCPU\tBusy%\n-\t-\t1.00\n",
+		Headers:   []string{"Core", "CPU", "Busy%"},
+	})
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/turbostat", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "turbostat --quiet sleep 5") {
+		t.Errorf("expected the command on the debug page")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/turbostat?download=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="turbostat-20240501-123000.tsv"` {
+		t.Errorf("unexpected Content-Disposition %q", got)
+	}
+	if got := rec.Body.String(); got != "Core\tCPU\tBusy%\n-\t-\t1.00\n" {
+		t.Errorf("expected the combined output as download, got %q", got)
+	}
+}
+
+func TestDebugHandler_RequiresAuth(t *testing.T) {
+	if NewAuthChain(AuthChainOptions{}).Enabled() {
+		t.Errorf("expected a chain without backends to be disabled")
+	}
+
+	store := NewDebugStore()
+	store.Set(&DebugCapture{Combined: "Core\tCPU\tBusy%\n"})
+	chain := NewAuthChain(AuthChainOptions{}, NewBasicAuthBackend("admin", "secret"))
+	if !chain.Enabled() {
+		t.Fatalf("expected a chain with a backend to be enabled")
+	}
+	h := chain.Middleware(DebugHandler(store))
+
+	if code := doRequest(h, "/debug/turbostat?download=1", nil); code != http.StatusUnauthorized {
+		t.Errorf("expected the capture to require authentication, got %d", code)
+	}
+	if code := doRequest(h, "/debug/turbostat?download=1", func(r *http.Request) { r.SetBasicAuth("admin", "secret") }); code != http.StatusOK {
+		t.Errorf("expected the capture with credentials, got %d", code)
+	}
+}
+
+func TestDebugCapture_AnalyzeColumns(t *testing.T) {
+	headers := []string{"Core", "CPU", "Busy%", "Busy%", "X", "PKG_%", "PKG%_"}
+	rows := [][]string{
+		{"-", "-", "1.00", "2.00", "n/a", "3", "4"},
+		{"0", "0", "1.00", "2.00", "n/a", "3", "4"},
+	}
+	parsed := NewTurbostatParser().ParseRowsSimple(headers, rows)
+
+	capture := &DebugCapture{Headers: headers}
+	capture.AnalyzeColumns(FlattenRows(parsed), nil)
+
+	if want := []string{"X"}; !slices.Equal(capture.UnparsedColumns, want) {
+		t.Errorf("expected unparsed columns %v, got %v", want, capture.UnparsedColumns)
+	}
+	if want := []string{"Busy%", "PKG%_ (same metric type as PKG_%)"}; !slices.Equal(capture.DuplicateColumns, want) {
+		t.Errorf("expected duplicate columns %v, got %v", want, capture.DuplicateColumns)
+	}
+}
+
+func TestDebugCapture_AnalyzeColumnsFiltered(t *testing.T) {
+	headers := []string{"Core", "CPU", "Busy%", "PkgWatt", "X"}
+	rows := [][]string{
+		{"-", "-", "1.00", "10.00", "n/a"},
+		{"0", "0", "1.00", "10.00", "n/a"},
+	}
+	parser := NewTurbostatParser()
+	parser.SetColumnFilter(func(header string) bool { return header != "PkgWatt" })
+	parsed := parser.ParseRowsSimple(headers, rows)
+
+	capture := &DebugCapture{Headers: headers}
+	capture.AnalyzeColumns(FlattenRows(parsed), parser.ColumnAllowed)
+
+	// hidden columns are expected to be missing and not reported as unparsed
+	if want := []string{"X"}; !slices.Equal(capture.UnparsedColumns, want) {
+		t.Errorf("expected unparsed columns %v, got %v", want, capture.UnparsedColumns)
+	}
+	if want := []string{"PkgWatt"}; !slices.Equal(capture.FilteredColumns, want) {
+		t.Errorf("expected filtered columns %v, got %v", want, capture.FilteredColumns)
+	}
+}
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"fmt"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -13,9 +15,35 @@ type TurbostatExporter struct {
 	coresPercent    *prometheus.GaugeVec
 	cpus            *prometheus.GaugeVec
 	cpusPercent     *prometheus.GaugeVec
+	// custom holds the metrics of CustomCounters keyed by turbostat column
+	custom map[string]*customMetric
+	// cpuHistograms replace the cpu series if set, see EnableCPUHistograms
+	cpuHistograms map[string]*prometheus.HistogramVec
+	// filter drops series before they are created, see SetSeriesFilter
+	filter     *SeriesFilter
+	suppressed *prometheus.GaugeVec
+	// limits drop levels exceeding them, see SetSeriesLimits
+	limits        *SeriesLimits
+	limitExceeded prometheus.Gauge
+	// limitDropped are the levels dropped by the previous update
+	limitDropped string
+	// reg is the registerer of the metrics, see Unregister
+	reg prometheus.Registerer
+}
+
+type customMetric struct {
+	counter CustomCounter
+	gauge   *prometheus.GaugeVec
 }
 
 func NewTurbostatExporter() *TurbostatExporter {
+	return NewTurbostatExporterWithRegisterer(WrapRegisterer(prometheus.DefaultRegisterer, DefaultMetricPrefix, nil))
+}
+
+// NewTurbostatExporterWithRegisterer creates an exporter whose metrics are
+// registered with reg instead of the default registry. The metric names have
+// no prefix, reg adds it, see WrapRegisterer.
+func NewTurbostatExporterWithRegisterer(reg prometheus.Registerer) *TurbostatExporter {
 	labelsTotal := []string{"type"}
 	labelsPackage := []string{"type", "package"}
 	labelsCore := []string{"type", "package", "core"}
@@ -23,40 +51,41 @@ func NewTurbostatExporter() *TurbostatExporter {
 
 	exporter := &TurbostatExporter{
 		packages: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "turbostat_packages",
+			Name: "packages",
 			Help: "Metrics for the whole package",
 		}, []string{"package", "type"}),
 		cores: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "turbostat_cores",
+			Name: "cores",
 		}, []string{"package", "core", "type"}),
 		cpus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "turbostat_cpus",
+			Name: "cpus",
 		}, []string{"package", "core", "cpu", "type"}),
 		packagesPercent: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "turbostat_packages_percent",
+			Name: "packages_percent",
 		}, labelsPackage),
 		coresPercent: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "turbostat_cores_percent",
+			Name: "cores_percent",
 		}, labelsCore),
 		cpusPercent: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "turbostat_cpus_percent",
+			Name: "cpus_percent",
 		}, labelsCPU),
 		totalPercent: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "turbostat_total_percent",
+			Name: "total_percent",
 			Help: "Metrics for the whole system in percentages. First line in output.",
 		}, labelsTotal),
 		total: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "turbostat_total",
+			Name: "total",
 			Help: "Metrics for the whole system. First line in output.",
 		}, labelsTotal),
+		reg: reg,
 	}
-	exporter.register()
+	exporter.register(reg)
 
 	return exporter
 }
 
-func (e *TurbostatExporter) register() {
-	prometheus.MustRegister(
+func (e *TurbostatExporter) register(reg prometheus.Registerer) {
+	reg.MustRegister(
 		e.total,
 		e.packages,
 		e.cores,
@@ -68,7 +97,125 @@ func (e *TurbostatExporter) register() {
 	)
 }
 
+// Unregister removes all metrics of the exporter, including custom counters,
+// from the registerer passed to NewTurbostatExporterWithRegisterer. The other
+// metrics must have been registered with the same registerer.
+func (e *TurbostatExporter) Unregister() {
+	for _, c := range []prometheus.Collector{
+		e.total,
+		e.packages,
+		e.cores,
+		e.cpus,
+		e.packagesPercent,
+		e.coresPercent,
+		e.cpusPercent,
+		e.totalPercent,
+	} {
+		e.reg.Unregister(c)
+	}
+	for _, m := range e.custom {
+		e.reg.Unregister(m.gauge)
+	}
+	for _, h := range e.cpuHistograms {
+		e.reg.Unregister(h)
+	}
+	if e.suppressed != nil {
+		e.reg.Unregister(e.suppressed)
+	}
+	if e.limitExceeded != nil {
+		e.reg.Unregister(e.limitExceeded)
+	}
+}
+
+// SetSeriesFilter restricts the generic metrics to the columns and levels
+// selected by f. The number of values dropped by the last update is
+// exported as turbostat_exporter_suppressed_series per level. Custom
+// counters are always exported.
+func (e *TurbostatExporter) SetSeriesFilter(reg prometheus.Registerer, f SeriesFilter) {
+	e.filter = &f
+	e.registerSuppressed(reg)
+}
+
+func (e *TurbostatExporter) registerSuppressed(reg prometheus.Registerer) {
+	if e.suppressed != nil {
+		return
+	}
+	e.suppressed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "exporter_suppressed_series",
+		Help: "Number of series dropped by the filters and series limits in the last collection.",
+	}, []string{"level"})
+	reg.MustRegister(e.suppressed)
+}
+
+// AddCustomCounters exports the columns of the given counters as their own
+// metrics, labeled according to their scope, instead of as a "type" of the
+// generic metrics.
+func (e *TurbostatExporter) AddCustomCounters(reg prometheus.Registerer, counters []CustomCounter) {
+	if e.custom == nil {
+		e.custom = map[string]*customMetric{}
+	}
+	for _, c := range counters {
+		var labels []string
+		switch c.Scope {
+		case "package":
+			labels = []string{"package"}
+		case "core":
+			labels = []string{"package", "core"}
+		default:
+			labels = []string{"package", "core", "cpu"}
+		}
+
+		help := c.Help
+		if c.Unit != "" {
+			help = fmt.Sprintf("%s Unit: %s.", help, c.Unit)
+		}
+		m := &customMetric{
+			counter: c,
+			gauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: c.Metric,
+				Help: help,
+			}, labels),
+		}
+		reg.MustRegister(m.gauge)
+		e.custom[c.Column] = m
+	}
+}
+
+// CustomCounters returns the counters added with AddCustomCounters.
+func (e *TurbostatExporter) CustomCounters() []CustomCounter {
+	counters := make([]CustomCounter, 0, len(e.custom))
+	for _, m := range e.custom {
+		counters = append(counters, m.counter)
+	}
+	return counters
+}
+
+// updateCustom sets the custom metric of the row's scope and reports whether
+// column belongs to a custom counter.
+func (e *TurbostatExporter) updateCustom(row TurbostatRow, column string, value float64) bool {
+	m, ok := e.custom[column]
+	if !ok {
+		return false
+	}
+	if m.counter.Scope != row.Category {
+		return true
+	}
+
+	labels := prometheus.Labels{"package": row.Pkg}
+	if row.Category != "package" {
+		labels["core"] = row.Core
+	}
+	if row.Category == "cpu" {
+		labels["cpu"] = row.CPU
+	}
+	m.gauge.With(labels).Set(value)
+	return true
+}
+
 func (e *TurbostatExporter) resetAll() {
+	for _, m := range e.custom {
+		m.gauge.Reset()
+	}
 	e.packages.Reset()
 	e.cores.Reset()
 	e.cpus.Reset()
@@ -82,6 +229,31 @@ func (e *TurbostatExporter) resetAll() {
 // Update uses the collected turbostat data of all TurbostatRows and configures the prometheus metrics.
 func (e *TurbostatExporter) Update(rows []TurbostatRow) {
 	e.resetAll()
+	suppressed := map[string]int{}
+	if len(e.custom) > 0 || e.filter != nil {
+		selected := make([]TurbostatRow, 0, len(rows))
+		for _, row := range rows {
+			if len(e.custom) > 0 {
+				row = e.extractCustom(row)
+			}
+			if e.filter != nil {
+				if !e.filter.LevelEnabled(row.Category) {
+					suppressed[row.Category] += len(row.Other) + len(row.OtherPercent)
+					continue
+				}
+				var dropped int
+				row, dropped = e.filter.apply(row)
+				suppressed[row.Category] += dropped
+			}
+			selected = append(selected, row)
+		}
+		rows = selected
+	}
+	// limits apply to the series which would actually be created
+	if e.limits != nil {
+		rows = e.limitSeries(rows, suppressed)
+	}
+
 	for _, row := range rows {
 		switch row.Category {
 		case "package":
@@ -99,6 +271,10 @@ func (e *TurbostatExporter) Update(rows []TurbostatRow) {
 				e.coresPercent.With(prometheus.Labels{"package": row.Pkg, "core": row.Core, "type": sanitizeHeader(t)}).Set(v)
 			}
 		case "cpu":
+			if e.cpuHistograms != nil {
+				e.observeCPU(row)
+				continue
+			}
 			for t, v := range row.Other {
 				e.cpus.With(prometheus.Labels{"package": row.Pkg, "core": row.Core, "cpu": row.CPU, "type": sanitizeHeader(t)}).Set(v)
 			}
@@ -114,4 +290,29 @@ func (e *TurbostatExporter) Update(rows []TurbostatRow) {
 			}
 		}
 	}
+	if e.suppressed != nil {
+		for _, level := range Levels {
+			e.suppressed.WithLabelValues(level).Set(float64(suppressed[level]))
+		}
+	}
+}
+
+// extractCustom exports the custom counter columns of row and returns a copy
+// of row without them.
+func (e *TurbostatExporter) extractCustom(row TurbostatRow) TurbostatRow {
+	other := make(map[string]float64, len(row.Other))
+	for t, v := range row.Other {
+		if !e.updateCustom(row, t, v) {
+			other[t] = v
+		}
+	}
+	otherPercent := make(map[string]float64, len(row.OtherPercent))
+	for t, v := range row.OtherPercent {
+		if !e.updateCustom(row, t, v) {
+			otherPercent[t] = v
+		}
+	}
+	row.Other = other
+	row.OtherPercent = otherPercent
+	return row
 }
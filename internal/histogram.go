@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CPUHistogramOptions configures the per-package histograms replacing the
+// per-CPU series.
+type CPUHistogramOptions struct {
+	// Columns are observed for every CPU, e.g. Busy% or Bzy_MHz.
+	Columns []string
+	// Native enables Prometheus native histograms, Classic the fixed buckets
+	// chosen by the unit of a column. At least one of them should be set.
+	Native  bool
+	Classic bool
+}
+
+// DefaultCPUHistogramColumns are the per-CPU columns whose distribution is
+// most useful on large hosts.
+var DefaultCPUHistogramColumns = []string{"Busy%", "Bzy_MHz", "CPU%c6"}
+
+// EnableCPUHistograms stops exporting turbostat_cpus and
+// turbostat_cpus_percent. Instead every collection observes the value of
+// each CPU in a histogram per package and column, e.g.
+// turbostat_cpus_busy_percent{package="0"}, so the series no longer grow with
+// the number of CPUs. Per-core and per-package series are kept.
+func (e *TurbostatExporter) EnableCPUHistograms(reg prometheus.Registerer, opts CPUHistogramOptions) {
+	e.cpuHistograms = map[string]*prometheus.HistogramVec{}
+	for _, column := range opts.Columns {
+		info := LookupColumn(column)
+		histogramOpts := prometheus.HistogramOpts{
+			Name: cpuHistogramName(column),
+			Help: fmt.Sprintf("Distribution of %s over the CPUs of a package, observed every collection. %s", column, info.Help),
+		}
+		if opts.Classic {
+			histogramOpts.Buckets = cpuHistogramBuckets(info.Unit)
+		}
+		if opts.Native {
+			histogramOpts.NativeHistogramBucketFactor = 1.1
+			histogramOpts.NativeHistogramMaxBucketNumber = 100
+			histogramOpts.NativeHistogramMinResetDuration = time.Hour
+		}
+		h := prometheus.NewHistogramVec(histogramOpts, []string{"package"})
+		reg.MustRegister(h)
+		e.cpuHistograms[column] = h
+	}
+}
+
+func cpuHistogramName(column string) string {
+	name := "cpus_" + sanitizeHeader(column)
+	if strings.Contains(column, "%") {
+		name += "_percent"
+	}
+	return name
+}
+
+// cpuHistogramBuckets returns classic buckets covering the usual range of a
+// unit.
+func cpuHistogramBuckets(unit string) []float64 {
+	switch unit {
+	case UnitPercent:
+		return prometheus.LinearBuckets(5, 5, 20)
+	case UnitMHz:
+		return prometheus.LinearBuckets(400, 200, 30)
+	case UnitCelsius:
+		return prometheus.LinearBuckets(30, 5, 15)
+	default:
+		return prometheus.DefBuckets
+	}
+}
+
+// observeCPU adds the histogram columns of a cpu row.
+func (e *TurbostatExporter) observeCPU(row TurbostatRow) {
+	for column, h := range e.cpuHistograms {
+		v, ok := row.Other[column]
+		if !ok {
+			v, ok = row.OtherPercent[column]
+		}
+		if ok {
+			h.WithLabelValues(row.Pkg).Observe(v)
+		}
+	}
+}
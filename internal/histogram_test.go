@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestExporter_CPUHistograms(t *testing.T) {
+	headers := []string{"Package", "Core", "CPU", "Busy%", "Bzy_MHz"}
+	rows := [][]string{
+		{"-", "-", "-", "40.00", "2000"},
+		{"0", "0", "0", "2.00", "800"},
+		{"0", "0", "4", "7.00", "1100"},
+		{"0", "1", "1", "90.00", "3000"},
+		{"1", "0", "2", "61.00", "2900"},
+	}
+	parsed := NewTurbostatParser().ParseRowsSimple(headers, rows)
+
+	registry := prometheus.NewPedanticRegistry()
+	exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+	exporter.EnableCPUHistograms(prefixed(registry), CPUHistogramOptions{Columns: []string{"Busy%", "Bzy_MHz"}, Classic: true})
+	exporter.Update(FlattenRows(parsed))
+
+	if n := testutil.CollectAndCount(exporter.cpus) + testutil.CollectAndCount(exporter.cpusPercent); n != 0 {
+		t.Errorf("expected no per-CPU series, got %d", n)
+	}
+
+	busy := histogramOf(t, registry, "turbostat_cpus_busy_percent", "0")
+	if busy.GetSampleCount() != 3 {
+		t.Errorf("expected the 3 CPUs of package 0 to be observed, got %d", busy.GetSampleCount())
+	}
+	if buckets := busy.GetBucket(); len(buckets) != 20 || buckets[len(buckets)-1].GetUpperBound() != 100 {
+		t.Errorf("expected percent buckets up to 100, got %v", buckets)
+	}
+
+	// each collection adds its CPUs to the distribution
+	exporter.Update(FlattenRows(parsed))
+	h := histogramOf(t, registry, "turbostat_cpus_bzy_mhz", "1")
+	if h.GetSampleCount() != 2 || h.GetSampleSum() != 5800 {
+		t.Errorf("expected 2 observations summing to 5800, got %d and %v", h.GetSampleCount(), h.GetSampleSum())
+	}
+}
+
+func TestExporter_CPUHistogramsNative(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+	exporter.EnableCPUHistograms(prefixed(registry), CPUHistogramOptions{Columns: []string{"Busy%"}, Native: true})
+	exporter.Update([]TurbostatRow{
+		{Category: "cpu", Pkg: "0", Core: "0", CPU: "0", Other: map[string]float64{}, OtherPercent: map[string]float64{"Busy%": 12}},
+	})
+
+	h := histogramOf(t, registry, "turbostat_cpus_busy_percent", "0")
+	if len(h.GetBucket()) != 0 || len(h.GetPositiveSpan()) == 0 {
+		t.Errorf("expected only native buckets, got %v", h)
+	}
+}
+
+// histogramOf returns the histogram of a package.
+func histogramOf(t *testing.T, g prometheus.Gatherer, name, pkg string) *dto.Histogram {
+	t.Helper()
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetLabel()[0].GetValue() == pkg {
+				return m.GetHistogram()
+			}
+		}
+	}
+	t.Fatalf("no %s for package %s", name, pkg)
+	return nil
+}
@@ -0,0 +1,340 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HistoryStore keeps recent collections in a ring buffer of fixed size. Time
+// is divided into slots of one resolution, collections finishing in the same
+// slot are averaged. Values are stored as float32 in one slice per slot,
+// indexed by series, so memory is bounded by
+// slots * max series * 4 bytes no matter how many CPUs a host has.
+type HistoryStore struct {
+	resolution time.Duration
+	maxSeries  int
+
+	mu     sync.RWMutex
+	index  map[historySeries]int
+	series []historySeries
+	slots  []historySlot
+	// head is the index of the newest slot, size the number of used slots
+	head, size int
+	warned     bool
+}
+
+type historySeries struct {
+	category, pkg, core, cpu, column string
+}
+
+type historySlot struct {
+	start  time.Time
+	count  int
+	values []float32
+}
+
+// NewHistoryStore keeps retention worth of collections at the given
+// resolution, for at most maxSeries series.
+func NewHistoryStore(retention, resolution time.Duration, maxSeries int) *HistoryStore {
+	resolution = max(resolution, time.Second)
+	return &HistoryStore{
+		resolution: resolution,
+		maxSeries:  maxSeries,
+		index:      map[historySeries]int{},
+		slots:      make([]historySlot, max(int(retention/resolution), 1)),
+	}
+}
+
+// Resolution returns the duration of a slot.
+func (h *HistoryStore) Resolution() time.Duration {
+	return h.resolution
+}
+
+// Retention returns the time covered by the ring buffer.
+func (h *HistoryStore) Retention() time.Duration {
+	return time.Duration(len(h.slots)) * h.resolution
+}
+
+// MaxBytes returns the memory used by the values once the buffer is full.
+func (h *HistoryStore) MaxBytes() int {
+	return len(h.slots) * h.maxSeries * 4
+}
+
+// Add records a collection. Collections older than the newest slot are
+// ignored.
+func (h *HistoryStore) Add(snap *Snapshot) {
+	start := snap.Timestamp.Truncate(h.resolution)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	slot := &h.slots[h.head]
+	switch {
+	case h.size > 0 && start.Equal(slot.start):
+	case h.size > 0 && start.Before(slot.start):
+		return
+	default:
+		if h.size > 0 {
+			h.head = (h.head + 1) % len(h.slots)
+		}
+		h.size = min(h.size+1, len(h.slots))
+		slot = &h.slots[h.head]
+		// reuse the values of the overwritten slot
+		slot.start = start
+		slot.count = 0
+		slot.values = slot.values[:0]
+	}
+	slot.count++
+
+	for i := range snap.Rows {
+		row := &snap.Rows[i]
+		for _, values := range []map[string]float64{row.Other, row.OtherPercent} {
+			for column, v := range values {
+				idx := h.seriesIndex(historySeries{category: row.Category, pkg: row.Pkg, core: row.Core, cpu: row.CPU, column: column})
+				if idx < 0 {
+					continue
+				}
+				for len(slot.values) <= idx {
+					slot.values = append(slot.values, float32(math.NaN()))
+				}
+				// running mean of the collections in this slot
+				old := slot.values[idx]
+				if math.IsNaN(float64(old)) {
+					slot.values[idx] = float32(v)
+				} else {
+					slot.values[idx] = old + (float32(v)-old)/float32(slot.count)
+				}
+			}
+		}
+	}
+}
+
+// seriesIndex returns the index of s, adding it if there is room. h.mu must
+// be held.
+func (h *HistoryStore) seriesIndex(s historySeries) int {
+	if idx, ok := h.index[s]; ok {
+		return idx
+	}
+	if len(h.series) >= h.maxSeries {
+		if !h.warned {
+			log.Warn().Msgf("History is limited to %d series, new series are not recorded", h.maxSeries)
+			h.warned = true
+		}
+		return -1
+	}
+	h.index[s] = len(h.series)
+	h.series = append(h.series, s)
+	return len(h.series) - 1
+}
+
+// HistoryQuery selects the series of one column in a time range.
+type HistoryQuery struct {
+	Column   string
+	From, To time.Time
+	// Step is the width of the returned points, at least the resolution.
+	Step   time.Duration
+	Filter SnapshotFilter
+}
+
+// ErrUnknownHistoryColumn is returned for columns without recorded values.
+var ErrUnknownHistoryColumn = errors.New("no history for this metric")
+
+type historyResponse struct {
+	Metric      string          `json:"metric"`
+	Unit        string          `json:"unit"`
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	StepSeconds float64         `json:"step_seconds"`
+	Series      []historyResult `json:"series"`
+}
+
+type historyResult struct {
+	Category string         `json:"category"`
+	Package  string         `json:"package,omitempty"`
+	Core     string         `json:"core,omitempty"`
+	CPU      string         `json:"cpu,omitempty"`
+	Points   []historyPoint `json:"points"`
+}
+
+// historyPoint aggregates the slots starting in [Timestamp, Timestamp+step),
+// Timestamp being a multiple of the step.
+type historyPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Avg       float64   `json:"avg"`
+	Count     int       `json:"count"`
+}
+
+// Query downsamples the matching series to points of q.Step.
+func (h *HistoryStore) Query(q HistoryQuery) (historyResponse, error) {
+	q.Step = max(q.Step, h.resolution)
+	resp := historyResponse{
+		Metric:      q.Column,
+		Unit:        LookupColumn(q.Column).Unit,
+		From:        q.From,
+		To:          q.To,
+		StepSeconds: q.Step.Seconds(),
+		Series:      []historyResult{},
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var indices []int
+	known := false
+	for idx, s := range h.series {
+		if s.column != q.Column {
+			continue
+		}
+		known = true
+		row := TurbostatRow{Category: s.category, Pkg: s.pkg, Core: s.core, CPU: s.cpu}
+		if !q.Filter.Match(&row) {
+			continue
+		}
+		indices = append(indices, idx)
+		result := historyResult{Category: s.category, Points: []historyPoint{}}
+		if s.category != "total" {
+			result.Package = s.pkg
+		}
+		if s.category == "core" || s.category == "cpu" {
+			result.Core = s.core
+		}
+		if s.category == "cpu" {
+			result.CPU = s.cpu
+		}
+		resp.Series = append(resp.Series, result)
+	}
+	if !known {
+		return resp, ErrUnknownHistoryColumn
+	}
+
+	// oldest to newest, so points are appended in order and only buckets with
+	// data are allocated
+	for i := range h.size {
+		slot := &h.slots[(h.head-h.size+1+i+len(h.slots))%len(h.slots)]
+		if slot.start.Before(q.From) || slot.start.After(q.To) {
+			continue
+		}
+		// aligned to multiples of the step, like the slots
+		bucket := slot.start.Truncate(q.Step)
+		for n, idx := range indices {
+			if idx >= len(slot.values) || math.IsNaN(float64(slot.values[idx])) {
+				continue
+			}
+			v := float32To64(slot.values[idx])
+			points := resp.Series[n].Points
+			if len(points) == 0 || !points[len(points)-1].Timestamp.Equal(bucket) {
+				resp.Series[n].Points = append(points, historyPoint{Timestamp: bucket, Min: v, Max: v, Avg: v, Count: 1})
+				continue
+			}
+			p := &points[len(points)-1]
+			p.Min = min(p.Min, v)
+			p.Max = max(p.Max, v)
+			p.Count++
+			p.Avg += (v - p.Avg) / float64(p.Count)
+		}
+	}
+	return resp, nil
+}
+
+// float32To64 converts v to the float64 with the shortest representation, so
+// e.g. 10.57 isn't reported as 10.569999694824219.
+func float32To64(v float32) float64 {
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+	return f
+}
+
+// HistoryHandler serves /api/v1/history. Parameters:
+//   - metric: the turbostat column, e.g. PkgWatt or Busy%
+//   - from, to: unix seconds, RFC 3339 or a duration like 15m meaning that
+//     long ago, the whole retention until now by default
+//   - step: width of the points as duration or seconds, the resolution by
+//     default
+//   - category, package, cpu: like /api/v1/snapshot
+func HistoryHandler(store *HistoryStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q, err := parseHistoryQuery(r.URL.Query(), time.Now(), store.Retention())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := store.Query(q)
+		if errors.Is(err, ErrUnknownHistoryColumn) {
+			http.Error(w, fmt.Sprintf("no history for metric %q", q.Column), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Error().Err(err).Msg("Failed to write history")
+		}
+	})
+}
+
+func parseHistoryQuery(query url.Values, now time.Time, retention time.Duration) (HistoryQuery, error) {
+	q := HistoryQuery{Column: query.Get("metric"), From: now.Add(-retention), To: now}
+	if q.Column == "" {
+		return q, errors.New("missing metric parameter")
+	}
+
+	var err error
+	if v := query.Get("from"); v != "" {
+		if q.From, err = parseHistoryTime(v, now); err != nil {
+			return q, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if q.To, err = parseHistoryTime(v, now); err != nil {
+			return q, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if q.To.Before(q.From) {
+		return q, errors.New("to must not be before from")
+	}
+
+	if v := query.Get("step"); v != "" {
+		if q.Step, err = parseHistoryDuration(v); err != nil || q.Step <= 0 {
+			return q, fmt.Errorf("invalid step %q, expected a positive duration like 60s", v)
+		}
+	}
+
+	q.Filter, err = ParseSnapshotFilter(query)
+	return q, err
+}
+
+func parseHistoryTime(v string, now time.Time) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither unix seconds, RFC 3339 nor a duration", v)
+}
+
+func parseHistoryDuration(v string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(v)
+}
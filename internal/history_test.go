@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func historySnapshot(ts time.Time, pkgWatt float64) *Snapshot {
+	return &Snapshot{Timestamp: ts, Rows: []TurbostatRow{
+		{Category: "package", Pkg: "0", Other: map[string]float64{"PkgWatt": pkgWatt}, OtherPercent: map[string]float64{}},
+		{Category: "package", Pkg: "1", Other: map[string]float64{"PkgWatt": pkgWatt * 2}, OtherPercent: map[string]float64{}},
+	}}
+}
+
+func TestHistoryStore_DownsamplesMinMaxAvg(t *testing.T) {
+	store := NewHistoryStore(time.Minute, 10*time.Second, 100)
+	start := time.Unix(1700000000, 0)
+	// two collections in the first slot are averaged to 15
+	store.Add(historySnapshot(start, 10))
+	store.Add(historySnapshot(start.Add(5*time.Second), 20))
+	store.Add(historySnapshot(start.Add(10*time.Second), 30))
+	store.Add(historySnapshot(start.Add(20*time.Second), 40))
+
+	resp, err := store.Query(HistoryQuery{
+		Column: "PkgWatt",
+		From:   start,
+		To:     start.Add(time.Minute),
+		Step:   20 * time.Second,
+		Filter: SnapshotFilter{Packages: []string{"0"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Series) != 1 || resp.Series[0].Package != "0" {
+		t.Fatalf("expected the series of package 0, got %+v", resp.Series)
+	}
+	points := resp.Series[0].Points
+	if len(points) != 2 {
+		t.Fatalf("expected 2 points, got %+v", points)
+	}
+	if p := points[0]; !p.Timestamp.Equal(start) || p.Min != 15 || p.Max != 30 || p.Avg != 22.5 || p.Count != 2 {
+		t.Errorf("unexpected first point %+v", p)
+	}
+	if p := points[1]; p.Min != 40 || p.Max != 40 || p.Count != 1 {
+		t.Errorf("unexpected second point %+v", p)
+	}
+}
+
+func TestHistoryStore_RingBufferAndSeriesLimit(t *testing.T) {
+	store := NewHistoryStore(30*time.Second, 10*time.Second, 1)
+	start := time.Unix(1700000000, 0)
+	for i := range 5 {
+		store.Add(historySnapshot(start.Add(time.Duration(i)*10*time.Second), float64(i)))
+	}
+
+	resp, err := store.Query(HistoryQuery{Column: "PkgWatt", From: start, To: start.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the first series fits, only the 3 newest slots are kept
+	if len(resp.Series) != 1 || len(resp.Series[0].Points) != 3 || resp.Series[0].Points[0].Avg != 2 {
+		t.Errorf("expected the 3 newest points of one series, got %+v", resp.Series)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	store := NewHistoryStore(time.Hour, time.Second, 100)
+	store.Add(historySnapshot(time.Now().Add(-time.Minute), 12))
+	handler := HistoryHandler(store)
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"metric=PkgWatt&from=10m&step=60", http.StatusOK},
+		{"metric=PkgWatt&from=2024-01-01T00:00:00Z&to=1700000000", http.StatusBadRequest},
+		{"metric=PkgWatt&step=-1s", http.StatusBadRequest},
+		{"metric=PkgWatt&category=socket", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+		{"metric=GFXWatt", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/history?"+tt.query, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%q: expected %d, got %d: %s", tt.query, tt.code, rec.Code, rec.Body)
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var resp historyResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Unit != UnitWatts || resp.StepSeconds != 60 || len(resp.Series) != 2 || resp.Series[1].Points[0].Avg != 24 {
+			t.Errorf("unexpected response %+v", resp)
+		}
+	}
+}
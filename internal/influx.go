@@ -0,0 +1,296 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxConfig configures the InfluxDB line protocol output.
+type InfluxConfig struct {
+	// URL is where lines are written to:
+	//   - http(s)://host:8086 for the InfluxDB HTTP API, v2 if Bucket is set
+	//     and v1 otherwise
+	//   - udp://host:8089 for the UDP listener of InfluxDB 1.x or Telegraf
+	//   - file:///path/to/file to append to a file, e.g. for the tail input of
+	//     Telegraf
+	URL string
+
+	// Database and RetentionPolicy select where the v1 API writes to,
+	// Username and Password authenticate.
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+
+	// Org and Bucket select where the v2 API writes to, Token authenticates.
+	Org    string
+	Bucket string
+	Token  string
+
+	// Measurement is the name of all lines, "turbostat" by default.
+	Measurement string
+	// Tags are added to every line, e.g. host.
+	Tags map[string]string
+	// BatchSize is the maximum number of lines per HTTP request.
+	BatchSize int
+	Timeout   time.Duration
+}
+
+// udpPayloadSize keeps datagrams below a typical MTU.
+const udpPayloadSize = 1400
+
+// InfluxTarget writes collections as InfluxDB line protocol. Every row is a
+// line with the topology as tags and the columns as fields, timestamped with
+// the collection:
+//
+//	turbostat,category=core,core=4,host=prox,package=0 Busy%=20.2,CoreTmp=31 1700000000000000000
+//
+// Writing the same line again overwrites the point, so retrying a partially
+// written collection doesn't create duplicates.
+type InfluxTarget struct {
+	cfg   InfluxConfig
+	write func(ctx context.Context, lines [][]byte) error
+
+	client  *http.Client
+	url     string
+	addr    string
+	path    string
+	headers http.Header
+}
+
+func NewInfluxTarget(cfg InfluxConfig) (*InfluxTarget, error) {
+	if cfg.Measurement == "" {
+		cfg.Measurement = "turbostat"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 5000
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q: %w", cfg.URL, err)
+	}
+
+	t := &InfluxTarget{cfg: cfg}
+	switch u.Scheme {
+	case "http", "https":
+		if err := t.setupHTTP(u); err != nil {
+			return nil, err
+		}
+		t.write = t.writeHTTP
+	case "udp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid InfluxDB URL %q, expected udp://host:port", cfg.URL)
+		}
+		t.addr = u.Host
+		t.write = t.writeUDP
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid InfluxDB URL %q, expected file:///path", cfg.URL)
+		}
+		t.path = u.Path
+		t.write = t.writeFile
+	default:
+		return nil, fmt.Errorf("unsupported InfluxDB URL %q, use http(s)://, udp:// or file://", cfg.URL)
+	}
+	return t, nil
+}
+
+func (t *InfluxTarget) setupHTTP(u *url.URL) error {
+	query := url.Values{"precision": {"ns"}}
+	t.headers = http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+	auth := PushAuth{}
+
+	if t.cfg.Bucket != "" {
+		u = u.JoinPath("api", "v2", "write")
+		query.Set("bucket", t.cfg.Bucket)
+		if t.cfg.Org != "" {
+			query.Set("org", t.cfg.Org)
+		}
+		if t.cfg.Token != "" {
+			t.headers.Set("Authorization", "Token "+t.cfg.Token)
+		}
+	} else {
+		if t.cfg.Database == "" {
+			return errors.New("InfluxDB needs a database for the v1 API or a bucket for the v2 API")
+		}
+		u = u.JoinPath("write")
+		query.Set("db", t.cfg.Database)
+		if t.cfg.RetentionPolicy != "" {
+			query.Set("rp", t.cfg.RetentionPolicy)
+		}
+		auth = PushAuth{Username: t.cfg.Username, Password: t.cfg.Password}
+	}
+
+	u.RawQuery = query.Encode()
+	t.url = u.String()
+	t.client = auth.Client(t.cfg.Timeout)
+	return nil
+}
+
+func (t *InfluxTarget) Name() string {
+	return "influxdb"
+}
+
+// Push writes the lines of all batches, split into requests of at most
+// BatchSize lines.
+func (t *InfluxTarget) Push(ctx context.Context, batches []PushBatch) error {
+	var lines [][]byte
+	for _, batch := range batches {
+		if batch.Snapshot != nil {
+			lines = t.appendLines(lines, batch.Snapshot)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return t.write(ctx, lines)
+}
+
+func (t *InfluxTarget) writeHTTP(ctx context.Context, lines [][]byte) error {
+	for chunk := range slices.Chunk(lines, t.cfg.BatchSize) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(bytes.Join(chunk, nil)))
+		if err != nil {
+			return PermanentError(err)
+		}
+		maps.Copy(req.Header, t.headers)
+
+		resp, err := t.client.Do(req)
+		if err != nil {
+			return err
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			continue
+		}
+
+		err = fmt.Errorf("InfluxDB answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		// like Telegraf, retry server errors and rate limiting, a bad
+		// request won't get better
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return err
+		}
+		return PermanentError(err)
+	}
+	return nil
+}
+
+func (t *InfluxTarget) writeUDP(ctx context.Context, lines [][]byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", t.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// a line longer than a datagram is sent on its own and may be truncated
+	// by the network, there is nothing better to do over UDP
+	var payload []byte
+	for _, line := range lines {
+		if len(payload) > 0 && len(payload)+len(line) > udpPayloadSize {
+			if _, err := conn.Write(payload); err != nil {
+				return err
+			}
+			payload = payload[:0]
+		}
+		payload = append(payload, line...)
+	}
+	_, err = conn.Write(payload)
+	return err
+}
+
+// writeFile opens the file for every write, so it can be rotated.
+func (t *InfluxTarget) writeFile(_ context.Context, lines [][]byte) error {
+	f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bytes.Join(lines, nil)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// appendLines appends one line per row of snap.
+func (t *InfluxTarget) appendLines(lines [][]byte, snap *Snapshot) [][]byte {
+	ts := strconv.FormatInt(snap.Timestamp.UnixNano(), 10)
+	for i := range snap.Rows {
+		row := &snap.Rows[i]
+		fields := make(map[string]float64, len(row.Other)+len(row.OtherPercent))
+		maps.Copy(fields, row.Other)
+		maps.Copy(fields, row.OtherPercent)
+		// line protocol has no representation for NaN and infinity
+		maps.DeleteFunc(fields, func(_ string, v float64) bool { return math.IsNaN(v) || math.IsInf(v, 0) })
+		if len(fields) == 0 {
+			continue
+		}
+
+		tags := maps.Clone(t.cfg.Tags)
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		tags["category"] = row.Category
+		if row.Category != "total" && row.Pkg != "" {
+			tags["package"] = row.Pkg
+		}
+		if row.Category == "core" || row.Category == "cpu" {
+			tags["core"] = row.Core
+		}
+		if row.Category == "cpu" {
+			tags["cpu"] = row.CPU
+		}
+
+		var line strings.Builder
+		line.WriteString(influxEscape(t.cfg.Measurement, ", "))
+		// sorted tags are what InfluxDB stores, sending them sorted saves it
+		// the work
+		for _, k := range slices.Sorted(maps.Keys(tags)) {
+			if tags[k] == "" {
+				continue
+			}
+			line.WriteString("," + influxEscape(k, ",= ") + "=" + influxEscape(tags[k], ",= "))
+		}
+		for i, k := range slices.Sorted(maps.Keys(fields)) {
+			if i == 0 {
+				line.WriteByte(' ')
+			} else {
+				line.WriteByte(',')
+			}
+			line.WriteString(influxEscape(k, ",= ") + "=" + strconv.FormatFloat(fields[k], 'f', -1, 64))
+		}
+		line.WriteString(" " + ts + "\n")
+		lines = append(lines, []byte(line.String()))
+	}
+	return lines
+}
+
+// influxEscape escapes the characters chars with a backslash, as line
+// protocol requires for names, tags and field keys.
+func influxEscape(s, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func influxTestBatch() PushBatch {
+	ts := time.Unix(1700000000, 0)
+	return PushBatch{Timestamp: ts, Snapshot: &Snapshot{Timestamp: ts, Rows: []TurbostatRow{
+		{Category: "total", Other: map[string]float64{"PkgWatt": 10.6}, OtherPercent: map[string]float64{"Busy%": 6.57}},
+		{Category: "package", Pkg: "0", Other: map[string]float64{"PkgWatt": 10.6}, OtherPercent: map[string]float64{}},
+		{Category: "cpu", Pkg: "0", Core: "4", CPU: "2", Other: map[string]float64{"Bzy_MHz": 2876}, OtherPercent: map[string]float64{"Busy%": 20.2}},
+	}}}
+}
+
+func TestInfluxTarget_LineProtocol(t *testing.T) {
+	target, err := NewInfluxTarget(InfluxConfig{URL: "file:///dev/null", Tags: map[string]string{"host": "prox 1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := target.appendLines(nil, influxTestBatch().Snapshot)
+
+	expected := []string{
+		"turbostat,category=total,host=prox\\ 1 Busy%=6.57,PkgWatt=10.6 1700000000000000000\n",
+		"turbostat,category=package,host=prox\\ 1,package=0 PkgWatt=10.6 1700000000000000000\n",
+		"turbostat,category=cpu,core=4,cpu=2,host=prox\\ 1,package=0 Busy%=20.2,Bzy_MHz=2876 1700000000000000000\n",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(lines))
+	}
+	for i, line := range lines {
+		if string(line) != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], line)
+		}
+	}
+}
+
+func TestInfluxTarget_V2BatchesRequests(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "hw" || r.URL.Query().Get("org") != "lab" || r.URL.Query().Get("precision") != "ns" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if r.Header.Get("Authorization") != "Token secret" {
+			t.Error("expected the token")
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	target, err := NewInfluxTarget(InfluxConfig{URL: server.URL, Org: "lab", Bucket: "hw", Token: "secret", BatchSize: 2, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := target.Push(context.Background(), []PushBatch{influxTestBatch()}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 || strings.Count(bodies[0], "\n") != 2 || strings.Count(bodies[1], "\n") != 1 {
+		t.Errorf("expected requests of 2 and 1 lines, got %q", bodies)
+	}
+}
+
+func TestInfluxTarget_V1RejectsBadRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/write" || r.URL.Query().Get("db") != "telemetry" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			t.Error("expected basic auth credentials")
+		}
+		http.Error(w, "partial write", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	target, err := NewInfluxTarget(InfluxConfig{URL: server.URL, Database: "telemetry", Username: "user", Password: "pass", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = target.Push(context.Background(), []PushBatch{influxTestBatch()})
+	if _, ok := err.(permanentError); !ok {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestInfluxTarget_UDPAndFile(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	udp, err := NewInfluxTarget(InfluxConfig{URL: "udp://" + conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := udp.Push(context.Background(), []PushBatch{influxTestBatch()}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, udpPayloadSize)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil || strings.Count(string(buf[:n]), "\n") != 3 {
+		t.Errorf("expected one datagram with 3 lines, got %q (%v)", buf[:n], err)
+	}
+
+	path := filepath.Join(t.TempDir(), "turbostat.lp")
+	file, err := NewInfluxTarget(InfluxConfig{URL: "file://" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := file.Push(context.Background(), []PushBatch{influxTestBatch()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, _ := os.ReadFile(path)
+	if strings.Count(string(content), "\n") != 6 {
+		t.Errorf("expected 6 appended lines, got %q", content)
+	}
+}
+
+func TestNewInfluxTarget_RequiresDatabaseOrBucket(t *testing.T) {
+	if _, err := NewInfluxTarget(InfluxConfig{URL: "http://localhost:8086"}); err == nil {
+		t.Error("expected an error without database or bucket")
+	}
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+)
+
+var instanceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+// ErrTooManyInstances is returned by IngestStore.Ingest when a new instance
+// would exceed the configured maximum.
+var ErrTooManyInstances = errors.New("too many ingested instances")
+
+// IngestStore keeps the metrics of turbostat output pushed by other hosts.
+// Every instance has its own parser and exporter, registered with an
+// "instance" label, and is dropped once no push arrived for the staleness
+// timeout.
+type IngestStore struct {
+	mu           sync.Mutex
+	registry     *prometheus.Registry
+	lastPush     *prometheus.GaugeVec
+	instances    map[string]*ingestInstance
+	newExporter  ExporterFactory
+	staleness    time.Duration
+	maxInstances int
+	now          func() time.Time
+}
+
+type ingestInstance struct {
+	parser   *TurbostatParser
+	exporter *TurbostatExporter
+	headers  []string
+	lastSeen time.Time
+}
+
+// NewIngestStore creates a store whose instances expire after staleness. A
+// maxInstances of 0 means no limit. The metrics about the pushes are
+// registered with reg.
+func NewIngestStore(staleness time.Duration, maxInstances int, newExporter ExporterFactory, reg prometheus.Registerer) *IngestStore {
+	s := &IngestStore{
+		registry: prometheus.NewRegistry(),
+		lastPush: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ingest_last_push_timestamp_seconds",
+			Help: "Unix time of the last turbostat output pushed by an instance.",
+		}, []string{"instance"}),
+		instances:    map[string]*ingestInstance{},
+		newExporter:  newExporter,
+		staleness:    staleness,
+		maxInstances: maxInstances,
+		now:          time.Now,
+	}
+	reg.MustRegister(s.lastPush)
+	return s
+}
+
+// Ingest parses raw turbostat output and replaces the metrics of instance.
+func (s *IngestStore) Ingest(instance string, content string) error {
+	headers, rows, err := ParseTurbostatOutput(content)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+
+	inst, ok := s.instances[instance]
+	if !ok {
+		if s.maxInstances > 0 && len(s.instances) >= s.maxInstances {
+			return ErrTooManyInstances
+		}
+		reg := prometheus.WrapRegistererWith(prometheus.Labels{"instance": instance}, s.registry)
+		inst = &ingestInstance{
+			parser:   NewTurbostatParser(),
+			exporter: s.newExporter(reg),
+		}
+		s.instances[instance] = inst
+		log.Info().Msgf("Ingesting turbostat output of new instance %s", instance)
+	}
+
+	inst.exporter.Update(FlattenRows(inst.parser.ParseRowsSimple(headers, rows)))
+	inst.headers = headers
+	inst.lastSeen = s.now()
+	s.lastPush.WithLabelValues(instance).Set(float64(inst.lastSeen.Unix()))
+	return nil
+}
+
+// pruneLocked drops all instances that did not push within the staleness
+// timeout. s.mu must be held.
+func (s *IngestStore) pruneLocked() {
+	if s.staleness <= 0 {
+		return
+	}
+	for name, inst := range s.instances {
+		if s.now().Sub(inst.lastSeen) > s.staleness {
+			inst.exporter.Unregister()
+			s.lastPush.DeleteLabelValues(name)
+			delete(s.instances, name)
+			log.Info().Msgf("Dropped metrics of instance %s, no push since %s", name, inst.lastSeen.Format(time.RFC3339))
+		}
+	}
+}
+
+// Columns returns the headers of the latest output of all instances, e.g. to
+// resolve the modules of columns only other hosts have, see ModuleGatherer.
+func (s *IngestStore) Columns() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var columns []string
+	for _, inst := range s.instances {
+		for _, h := range inst.headers {
+			if !slices.Contains(columns, h) {
+				columns = append(columns, h)
+			}
+		}
+	}
+	return columns
+}
+
+// Gather implements prometheus.Gatherer, so the ingested metrics can be served
+// next to the local ones.
+func (s *IngestStore) Gather() ([]*dto.MetricFamily, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	return s.registry.Gather()
+}
+
+// IngestHandler accepts raw turbostat output with POST /api/v1/ingest?instance=<name>.
+func IngestHandler(store *IngestStore, maxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		instance := r.URL.Query().Get("instance")
+		if !instanceNamePattern.MatchString(instance) {
+			http.Error(w, "instance must be 1-128 letters, digits or _.:-", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("body exceeds %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		if err := store.Ingest(instance, string(body)); err != nil {
+			if errors.Is(err, ErrTooManyInstances) {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			log.Warn().Err(err).Msgf("Rejected turbostat output of instance %s", instance)
+			http.Error(w, "failed to parse turbostat output: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIngestHandler_ExposesInstancesUntilStale(t *testing.T) {
+	content, err := os.ReadFile("../data/sandy-bridge.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	store := NewIngestStore(time.Minute, 1, func(reg prometheus.Registerer) *TurbostatExporter {
+		return NewTurbostatExporterWithRegisterer(prefixed(reg))
+	}, prometheus.NewRegistry())
+	store.now = func() time.Time { return now }
+	handler := IngestHandler(store, 1<<20)
+
+	post := func(instance, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest?instance="+instance, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("appliance-1", string(content)); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if code := post("appliance-2", string(content)); code != http.StatusTooManyRequests {
+		t.Errorf("expected instance limit to answer 429, got %d", code)
+	}
+	if code := post("bad/name", string(content)); code != http.StatusBadRequest {
+		t.Errorf("expected invalid instance to answer 400, got %d", code)
+	}
+	if code := post("appliance-1", "no turbostat here"); code != http.StatusBadRequest {
+		t.Errorf("expected unparsable body to answer 400, got %d", code)
+	}
+
+	families, err := store.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, mf := range families {
+		if mf.GetName() != "turbostat_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "instance" && l.GetValue() == "appliance-1" {
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("expected turbostat_total with instance label appliance-1")
+	}
+
+	now = now.Add(2 * time.Minute)
+	families, err = store.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 0 {
+		t.Errorf("expected stale instance to be dropped, got %d metric families", len(families))
+	}
+	if code := post("appliance-2", string(content)); code != http.StatusNoContent {
+		t.Errorf("expected a new instance to be accepted after the stale one was dropped, got %d", code)
+	}
+}
+
+func TestIngestStore_InstancesWithoutConstantLabels(t *testing.T) {
+	content, err := os.ReadFile("../data/sandy-bridge.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// like main: the local metrics get the constant labels of this host, the
+	// exporters of ingested instances only the prefix
+	local := prometheus.NewRegistry()
+	labels := prometheus.Labels{"node": "receiver"}
+	store := NewIngestStore(time.Minute, 0, func(reg prometheus.Registerer) *TurbostatExporter {
+		return NewTurbostatExporterWithRegisterer(WrapRegisterer(reg, DefaultMetricPrefix, nil))
+	}, WrapRegisterer(local, DefaultMetricPrefix, labels))
+	if err := store.Ingest("appliance-1", string(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := store.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) == 0 {
+		t.Fatal("expected metrics of the ingested instance")
+	}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "node" {
+					t.Fatalf("expected %s of appliance-1 without the node label of the receiver, got %v", mf.GetName(), m.GetLabel())
+				}
+			}
+		}
+	}
+
+	families, err = local.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetName() != "turbostat_ingest_last_push_timestamp_seconds" ||
+		!strings.Contains(families[0].GetMetric()[0].String(), "receiver") {
+		t.Errorf("expected the push timestamp of the receiver with its node label, got %v", families)
+	}
+}
+
+func TestIngestStore_ModulesOfIngestedColumns(t *testing.T) {
+	store := NewIngestStore(time.Minute, 0, func(reg prometheus.Registerer) *TurbostatExporter {
+		return NewTurbostatExporterWithRegisterer(prefixed(reg))
+	}, prometheus.NewRegistry())
+	// C1E% is neither in the catalog nor in the local headers
+	content := "Core\tCPU\tBusy%\tC1E%\tPkgWatt\n" +
+		"-\t-\t10.00\t60.00\t20.00\n" +
+		"0\t0\t10.00\t60.00\t20.00\n"
+	if err := store.Ingest("remote", content); err != nil {
+		t.Fatal(err)
+	}
+
+	g := ModuleGatherer(store, DefaultMetricPrefix, []string{ModuleCStates}, store.Columns)
+	expected := `
+# HELP turbostat_total_percent Metrics for the whole system in percentages. First line in output.
+# TYPE turbostat_total_percent gauge
+turbostat_total_percent{instance="remote",type="c1e"} 60
+`
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected), "turbostat_total", "turbostat_total_percent"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,227 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// turbostatGroups are the column groups turbostat accepts for --show/--hide.
+var turbostatGroups = []string{"all", "topology", "idle", "frequency", "power", "cpuidle", "hwidle", "swidle", "other", "sysfs"}
+
+var columnNamePattern = regexp.MustCompile(`^[A-Za-z0-9_%]+$`)
+
+// TurbostatOptions are the per-run turbostat settings.
+type TurbostatOptions struct {
+	// Show and Hide are passed as --show/--hide and accept column or group names.
+	Show []string
+	Hide []string
+	// CPUs is a CPU list passed as --cpu, empty means all CPUs.
+	CPUs string
+}
+
+// Args returns the turbostat arguments for the options, without the command
+// that turbostat runs for the measurement interval.
+func (o TurbostatOptions) Args() []string {
+	args := []string{"--quiet"}
+	if len(o.Show) > 0 {
+		args = append(args, "--show", strings.Join(o.Show, ","))
+	}
+	if len(o.Hide) > 0 {
+		args = append(args, "--hide", strings.Join(o.Hide, ","))
+	}
+	if o.CPUs != "" {
+		args = append(args, "--cpu", o.CPUs)
+	}
+	return args
+}
+
+// Merge returns o with every non-empty field of override replacing the one of o.
+func (o TurbostatOptions) Merge(override TurbostatOptions) TurbostatOptions {
+	if len(override.Show) > 0 {
+		o.Show = override.Show
+	}
+	if len(override.Hide) > 0 {
+		o.Hide = override.Hide
+	}
+	if override.CPUs != "" {
+		o.CPUs = override.CPUs
+	}
+	return o
+}
+
+// Narrow returns o restricted by the options r of a request, so a request can
+// select fewer columns and CPUs than configured but never more: show and the
+// CPU lists are intersected, hide is joined. Groups can't be resolved to
+// columns, so if either show contains one, the configured show is kept and
+// callers have to filter the parsed columns with both options.
+func (o TurbostatOptions) Narrow(r TurbostatOptions) (TurbostatOptions, error) {
+	switch {
+	case len(r.Show) == 0:
+	case len(o.Show) == 0:
+		o.Show = r.Show
+	case !slices.ContainsFunc(slices.Concat(o.Show, r.Show), isTurbostatGroup):
+		var show []string
+		for _, c := range r.Show {
+			if slices.Contains(o.Show, c) {
+				show = append(show, c)
+			}
+		}
+		if len(show) == 0 {
+			return o, fmt.Errorf("show selects none of the configured columns %s", strings.Join(o.Show, ","))
+		}
+		o.Show = show
+	}
+
+	hide := slices.Clone(o.Hide)
+	for _, c := range r.Hide {
+		if !slices.Contains(hide, c) {
+			hide = append(hide, c)
+		}
+	}
+	o.Hide = hide
+
+	if r.CPUs != "" {
+		requested, err := ParseCPUList(r.CPUs)
+		if err != nil {
+			return o, err
+		}
+		if o.CPUs != "" {
+			configured, err := ParseCPUList(o.CPUs)
+			if err != nil {
+				return o, err
+			}
+			requested = slices.DeleteFunc(requested, func(c int) bool { return !slices.Contains(configured, c) })
+			if len(requested) == 0 {
+				return o, fmt.Errorf("cpu selects none of the configured CPUs %s", o.CPUs)
+			}
+		}
+		o.CPUs = FormatCPUList(requested)
+	}
+	return o, nil
+}
+
+func isTurbostatGroup(name string) bool {
+	return slices.Contains(turbostatGroups, name)
+}
+
+// ColumnAllowed reports whether a column selected by these options may be
+// stored. turbostat always prints some columns (e.g. the topology columns)
+// regardless of --show, so the parser filters again. Group names can't be
+// resolved to columns here, so a --show containing a group keeps everything.
+func (o TurbostatOptions) ColumnAllowed(header string) bool {
+	if slices.Contains(o.Hide, header) {
+		return false
+	}
+	if len(o.Show) == 0 {
+		return true
+	}
+	for _, s := range o.Show {
+		if s == header || slices.Contains(turbostatGroups, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that all column names and the CPU list are well formed.
+func (o TurbostatOptions) Validate() error {
+	for _, c := range slices.Concat(o.Show, o.Hide) {
+		if !columnNamePattern.MatchString(c) {
+			return fmt.Errorf("invalid column name %q", c)
+		}
+	}
+	if o.CPUs != "" {
+		if _, err := ParseCPUList(o.CPUs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allowedExtraArgs are the turbostat flags which may be passed through
+// TurbostatInvocation.ExtraArgs, mapped to whether they take a value. Flags
+// controlling the output format or interval are managed by the exporter.
+var allowedExtraArgs = map[string]bool{
+	"--debug":   false,
+	"--Joules":  false,
+	"--Summary": false,
+	"--no-msr":  false,
+	"--no-perf": false,
+	"--force":   false,
+	"--enable":  true,
+	"--disable": true,
+	"--add":     true,
+}
+
+// TurbostatInvocation describes how the turbostat binary is executed.
+type TurbostatInvocation struct {
+	// Binary is an absolute path or a name looked up in PATH.
+	Binary    string
+	ExtraArgs []string
+	// Options are the defaults for every run, probes may override them.
+	Options TurbostatOptions
+}
+
+func NewTurbostatInvocation() TurbostatInvocation {
+	return TurbostatInvocation{Binary: "turbostat"}
+}
+
+// Validate resolves the binary and checks the extra arguments against
+// allowedExtraArgs.
+func (i *TurbostatInvocation) Validate() error {
+	path, err := exec.LookPath(i.Binary)
+	if err != nil {
+		return fmt.Errorf("turbostat binary %q: %w", i.Binary, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("turbostat binary %q: %w", i.Binary, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("turbostat binary %q is not a regular file", path)
+	}
+	i.Binary = path
+
+	for idx := 0; idx < len(i.ExtraArgs); idx++ {
+		arg := i.ExtraArgs[idx]
+		name, _, hasValue := strings.Cut(arg, "=")
+		takesValue, ok := allowedExtraArgs[name]
+		if !ok {
+			return fmt.Errorf("turbostat argument %q is not supported", arg)
+		}
+		if takesValue && !hasValue {
+			// value is the next argument
+			idx++
+			if idx == len(i.ExtraArgs) || strings.HasPrefix(i.ExtraArgs[idx], "-") {
+				return fmt.Errorf("turbostat argument %q needs a value", arg)
+			}
+		}
+		if !takesValue && hasValue {
+			return fmt.Errorf("turbostat argument %q takes no value", name)
+		}
+	}
+
+	return i.Options.Validate()
+}
+
+// IntervalArgv returns the arguments (without the binary) for a long running
+// turbostat printing a block every interval until it is stopped.
+func (i TurbostatInvocation) IntervalArgv(interval time.Duration) []string {
+	args := i.Options.Args()
+	args = append(args, i.ExtraArgs...)
+	return append(args, "--interval", strconv.FormatFloat(interval.Seconds(), 'f', -1, 64))
+}
+
+// Argv returns the arguments (without the binary) for a run of the given
+// number of seconds. opts override the configured default options.
+func (i TurbostatInvocation) Argv(seconds int, opts TurbostatOptions) []string {
+	args := i.Options.Merge(opts).Args()
+	args = append(args, i.ExtraArgs...)
+	return append(args, "sleep", strconv.Itoa(seconds))
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTurbostatInvocation_Argv(t *testing.T) {
+	inv := NewTurbostatInvocation()
+	inv.ExtraArgs = []string{"--Joules"}
+	inv.Options = TurbostatOptions{Show: []string{"PkgWatt"}, CPUs: "0"}
+
+	want := []string{"--quiet", "--show", "PkgWatt", "--cpu", "1,2", "--Joules", "sleep", "5"}
+	if got := inv.Argv(5, TurbostatOptions{CPUs: "1,2"}); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestTurbostatInvocation_IntervalArgv(t *testing.T) {
+	inv := NewTurbostatInvocation()
+	inv.ExtraArgs = []string{"--Joules"}
+
+	want := []string{"--quiet", "--Joules", "--interval", "0.5"}
+	if got := inv.IntervalArgv(500 * time.Millisecond); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestTurbostatInvocation_Validate(t *testing.T) {
+	tests := []struct {
+		binary string
+		args   []string
+		opts   TurbostatOptions
+		valid  bool
+	}{
+		{"sh", []string{"--debug", "--enable", "Time_Of_Day_Seconds"}, TurbostatOptions{}, true},
+		{"sh", []string{"--add=msr0x10,u64,cpu,delta,tsc"}, TurbostatOptions{}, true},
+		{"/nonexistent/turbostat", nil, TurbostatOptions{}, false},
+		{"sh", []string{"--out", "/tmp/x"}, TurbostatOptions{}, false},
+		{"sh", []string{"--enable"}, TurbostatOptions{}, false},
+		{"sh", []string{"--debug=1"}, TurbostatOptions{}, false},
+		{"sh", nil, TurbostatOptions{Show: []string{"Busy%;"}}, false},
+		{"sh", nil, TurbostatOptions{CPUs: "3-1"}, false},
+	}
+	for _, tt := range tests {
+		inv := TurbostatInvocation{Binary: tt.binary, ExtraArgs: tt.args, Options: tt.opts}
+		err := inv.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%s %v %+v: expected valid=%v, got error %v", tt.binary, tt.args, tt.opts, tt.valid, err)
+		}
+	}
+}
+
+func TestTurbostatOptions_ColumnAllowed(t *testing.T) {
+	show := TurbostatOptions{Show: []string{"Busy%", "PkgWatt"}}
+	if !show.ColumnAllowed("PkgWatt") || show.ColumnAllowed("IRQ") {
+		t.Errorf("expected --show to restrict the stored columns")
+	}
+
+	group := TurbostatOptions{Show: []string{"power"}, Hide: []string{"IRQ"}}
+	if !group.ColumnAllowed("Busy%") || group.ColumnAllowed("IRQ") {
+		t.Errorf("expected groups to keep all columns except hidden ones")
+	}
+}
+
+func TestTurbostatOptions_Narrow(t *testing.T) {
+	configured := TurbostatOptions{Show: []string{"PkgWatt", "Busy%"}, Hide: []string{"IRQ"}, CPUs: "0-3"}
+
+	got, err := configured.Narrow(TurbostatOptions{Show: []string{"Busy%", "CPU%c6"}, Hide: []string{"SMI"}, CPUs: "2-7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"--quiet", "--show", "Busy%", "--hide", "IRQ,SMI", "--cpu", "2-3"}
+	if args := got.Args(); !slices.Equal(args, want) {
+		t.Errorf("expected %v, got %v", want, args)
+	}
+
+	// hidden columns stay hidden
+	if got, _ := configured.Narrow(TurbostatOptions{Show: []string{"PkgWatt"}}); !got.ColumnAllowed("PkgWatt") || got.ColumnAllowed("IRQ") {
+		t.Errorf("unexpected narrowed options %+v", got)
+	}
+
+	// groups keep the configured show
+	if got, _ := configured.Narrow(TurbostatOptions{Show: []string{"idle"}}); !slices.Equal(got.Show, configured.Show) {
+		t.Errorf("expected the configured show, got %v", got.Show)
+	}
+
+	// without configured options the request applies as is
+	if got, _ := (TurbostatOptions{}).Narrow(TurbostatOptions{Show: []string{"idle"}, CPUs: "1"}); !slices.Equal(got.Show, []string{"idle"}) || got.CPUs != "1" {
+		t.Errorf("unexpected narrowed options %+v", got)
+	}
+
+	for _, r := range []TurbostatOptions{
+		{Show: []string{"CPU%c6"}},
+		{CPUs: "4-7"},
+	} {
+		if _, err := configured.Narrow(r); err == nil {
+			t.Errorf("expected %+v to select nothing", r)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ParseModules reads module names like the collect[] query parameter of
+// /metrics. Each value may be a comma separated list. No values select
+// every module.
+func ParseModules(values []string) ([]string, error) {
+	var modules []string
+	for _, v := range values {
+		for m := range strings.SplitSeq(v, ",") {
+			if m = strings.TrimSpace(m); m == "" {
+				continue
+			}
+			if !slices.Contains(Modules, m) {
+				return nil, fmt.Errorf("unknown module %q, expected one of %v", m, Modules)
+			}
+			if !slices.Contains(modules, m) {
+				modules = append(modules, m)
+			}
+		}
+	}
+	if len(modules) == 0 {
+		return slices.Clone(Modules), nil
+	}
+	return modules, nil
+}
+
+// genericFamilies hold the series of all columns with a type label.
+var genericFamilies = []string{"total", "packages", "cores", "cpus"}
+
+// aggregateSuffixes are added to the generic families by the Aggregator.
+var aggregateSuffixes = []string{"", "_min", "_max", "_avg", "_last", "_quantile"}
+
+// ModuleGatherer returns the turbostat series of g belonging to modules,
+// prefix is the metric prefix the exporter registers its metrics with.
+// The modules of the type labels are looked up in the catalog and in the
+// column names returned by columns, e.g. the headers of the latest
+// collection, which resolve C-states and custom counters. Series of unknown
+// columns belong to ModuleOther. Metrics about the exporter itself and
+// custom counter families are always kept.
+func ModuleGatherer(g prometheus.Gatherer, prefix string, modules []string, columns func() []string) prometheus.Gatherer {
+	if len(modules) == len(Modules) {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+
+		// type label -> module per percent and non-percent family
+		types := map[bool]map[string]string{false: {}, true: {}}
+		histograms := map[string]string{}
+		add := func(column string) {
+			module := ColumnModule(column)
+			types[strings.Contains(column, "%")][sanitizeHeader(column)] = module
+			histograms[prefix+cpuHistogramName(column)] = module
+		}
+		for column := range knownColumns {
+			add(column)
+		}
+		for _, column := range columns() {
+			add(column)
+		}
+
+		res := make([]*dto.MetricFamily, 0, len(families))
+		for _, mf := range families {
+			if module, ok := histograms[mf.GetName()]; ok {
+				if slices.Contains(modules, module) {
+					res = append(res, mf)
+				}
+				continue
+			}
+			name, ok := strings.CutPrefix(mf.GetName(), prefix)
+			if !ok {
+				res = append(res, mf)
+				continue
+			}
+			percent, ok := genericFamily(name)
+			if !ok {
+				res = append(res, mf)
+				continue
+			}
+			var metrics []*dto.Metric
+			for _, m := range mf.Metric {
+				module, ok := types[percent][typeLabel(m)]
+				if !ok {
+					module = ModuleOther
+				}
+				if slices.Contains(modules, module) {
+					metrics = append(metrics, m)
+				}
+			}
+			if len(metrics) == 0 {
+				continue
+			}
+			// g may hand out the same families to other users
+			mf = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Unit: mf.Unit, Metric: metrics}
+			res = append(res, mf)
+		}
+		return res, err
+	})
+}
+
+// genericFamily reports whether name, without the metric prefix, is a generic
+// or aggregated family and whether it holds percent columns.
+func genericFamily(name string) (percent, ok bool) {
+	for _, base := range genericFamilies {
+		for _, p := range []string{"", "_percent"} {
+			for _, suffix := range aggregateSuffixes {
+				if name == base+p+suffix {
+					return p != "", true
+				}
+			}
+		}
+	}
+	return false, false
+}
+
+func typeLabel(m *dto.Metric) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == "type" {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
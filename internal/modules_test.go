@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseModules(t *testing.T) {
+	modules, err := ParseModules([]string{"power", "cstates,frequency", "power"})
+	if err != nil || !slices.Equal(modules, []string{"power", "cstates", "frequency"}) {
+		t.Errorf("unexpected modules %v (%v)", modules, err)
+	}
+	if modules, _ := ParseModules(nil); !slices.Equal(modules, Modules) {
+		t.Errorf("expected all modules without values, got %v", modules)
+	}
+	if _, err := ParseModules([]string{"power,gpu"}); err == nil {
+		t.Error("expected an unknown module to be rejected")
+	}
+}
+
+func TestColumnModule(t *testing.T) {
+	for column, expected := range map[string]string{
+		"PkgWatt": ModulePower,
+		"CPU%c6":  ModuleCStates,
+		"C1E":     ModuleCStates,
+		"Bzy_MHz": ModuleFrequency,
+		"PkgTmp":  ModuleTemperature,
+		"Busy%":   ModuleUtilization,
+		"MemMHz":  ModuleFrequency,
+		"Foo":     ModuleOther,
+	} {
+		if module := ColumnModule(column); module != expected {
+			t.Errorf("expected %s in %s, got %s", column, expected, module)
+		}
+	}
+}
+
+func TestModuleGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+	exporter.Update([]TurbostatRow{
+		{
+			Category:     "total",
+			Other:        map[string]float64{"PkgWatt": 20, "Bzy_MHz": 2000, "PkgTmp": 50},
+			OtherPercent: map[string]float64{"Busy%": 10, "C1E%": 60},
+		},
+	})
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "other_metric", Help: "Always kept."})
+	registry.MustRegister(other)
+
+	// C1E% is only known from the headers
+	columns := func() []string { return []string{"Busy%", "C1E%", "PkgWatt"} }
+	g := ModuleGatherer(registry, DefaultMetricPrefix, []string{ModulePower, ModuleCStates}, columns)
+	expected := `
+# HELP other_metric Always kept.
+# TYPE other_metric gauge
+other_metric 0
+# HELP turbostat_total Metrics for the whole system. First line in output.
+# TYPE turbostat_total gauge
+turbostat_total{type="pkgwatt"} 20
+# HELP turbostat_total_percent Metrics for the whole system in percentages. First line in output.
+# TYPE turbostat_total_percent gauge
+turbostat_total_percent{type="c1e"} 60
+`
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected), "turbostat_total", "turbostat_total_percent", "other_metric"); err != nil {
+		t.Error(err)
+	}
+
+	// the underlying registry is unchanged
+	if n := testutil.CollectAndCount(exporter.total); n != 3 {
+		t.Errorf("expected 3 series in the exporter, got %d", n)
+	}
+}
@@ -0,0 +1,423 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+)
+
+// MQTTConfig configures the MQTT output.
+type MQTTConfig struct {
+	// Broker is a URL like "tcp://broker:1883", "ssl://broker:8883" or
+	// "ws://broker:9001/mqtt".
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// TopicPrefix is prepended to all topics, e.g. "turbostat/<hostname>".
+	TopicPrefix string
+	QoS         byte
+	Retain      bool
+	Timeout     time.Duration
+
+	// CAFile, CertFile and KeyFile configure TLS for ssl://, tls://, mqtts://
+	// and wss:// brokers. Without CAFile the system roots are used.
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+
+	// Discovery publishes Home Assistant MQTT discovery configs below
+	// DiscoveryPrefix, "homeassistant" by default.
+	Discovery       bool
+	DiscoveryPrefix string
+	// NodeID identifies the device in Home Assistant, usually the hostname.
+	NodeID  string
+	Version string
+}
+
+// MQTTPublisher publishes every collection as one JSON message to
+// <prefix>/state and every value to its own topic, e.g.
+// <prefix>/package/0/pkgwatt. <prefix>/status is "online" while the
+// exporter is connected and "offline" otherwise, using the last will of
+// the connection.
+type MQTTPublisher struct {
+	cfg    MQTTConfig
+	client mqtt.Client
+
+	mu     sync.Mutex
+	latest *Snapshot
+	// discovered holds the object ids of the published discovery configs
+	discovered map[string]bool
+	wake       chan struct{}
+}
+
+func NewMQTTPublisher(cfg MQTTConfig) (*MQTTPublisher, error) {
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d, expected 0, 1 or 2", cfg.QoS)
+	}
+	if cfg.DiscoveryPrefix == "" {
+		cfg.DiscoveryPrefix = "homeassistant"
+	}
+	cfg.TopicPrefix = strings.TrimSuffix(cfg.TopicPrefix, "/")
+
+	p := &MQTTPublisher{
+		cfg:        cfg,
+		discovered: map[string]bool{},
+		wake:       make(chan struct{}, 1),
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(cfg.Timeout).
+		SetWriteTimeout(cfg.Timeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetBinaryWill(p.topic("status"), []byte("offline"), cfg.QoS, true).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Warn().Err(err).Msgf("Lost connection to MQTT broker %s", cfg.Broker)
+		})
+
+	if needsTLS(cfg.Broker) || cfg.CAFile != "" || cfg.CertFile != "" {
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	p.client = mqtt.NewClient(opts)
+	return p, nil
+}
+
+func needsTLS(broker string) bool {
+	for _, scheme := range []string{"ssl://", "tls://", "mqtts://", "wss://"} {
+		if strings.HasPrefix(broker, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg MQTTConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify, //nolint:gosec // opt-in for self-signed home-lab brokers
+	}
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Connect starts connecting to the broker. It doesn't wait for the
+// connection, which is retried in the background until it succeeds.
+func (p *MQTTPublisher) Connect() {
+	p.client.Connect()
+}
+
+// onConnect marks the exporter online and publishes the discovery configs
+// again, the broker may have lost them.
+func (p *MQTTPublisher) onConnect(client mqtt.Client) {
+	log.Info().Msgf("Connected to MQTT broker %s", p.cfg.Broker)
+	client.Publish(p.topic("status"), p.cfg.QoS, true, "online")
+
+	p.mu.Lock()
+	clear(p.discovered)
+	p.mu.Unlock()
+	p.wakeUp()
+}
+
+func (p *MQTTPublisher) topic(parts ...string) string {
+	return p.cfg.TopicPrefix + "/" + strings.Join(parts, "/")
+}
+
+// Record stores the snapshot of a collection and schedules its publication.
+func (p *MQTTPublisher) Record(snap *Snapshot) {
+	p.mu.Lock()
+	p.latest = snap
+	p.mu.Unlock()
+	p.wakeUp()
+}
+
+func (p *MQTTPublisher) wakeUp() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes recorded collections until ctx is done. Collections recorded
+// while a publication is in progress are skipped except for the latest one.
+func (p *MQTTPublisher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-p.wake:
+			p.mu.Lock()
+			snap := p.latest
+			p.mu.Unlock()
+			if snap == nil || !p.client.IsConnectionOpen() {
+				continue
+			}
+			if err := p.publish(snap); err != nil {
+				log.Error().Err(err).Msg("Failed to publish to MQTT")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+	// discoveryID is the object id of a discovery config, it is marked as
+	// discovered once the broker accepted the message
+	discoveryID string
+}
+
+func (p *MQTTPublisher) publish(snap *Snapshot) error {
+	messages, err := p.messages(snap)
+	if err != nil {
+		return err
+	}
+
+	tokens := make([]mqtt.Token, 0, len(messages))
+	for _, m := range messages {
+		tokens = append(tokens, p.client.Publish(m.topic, p.cfg.QoS, m.retain, m.payload))
+	}
+	var errs []error
+	for i, t := range tokens {
+		if !t.WaitTimeout(p.cfg.Timeout) {
+			errs = append(errs, errors.New("timeout waiting for the broker"))
+			break
+		}
+		if err := t.Error(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// failed discovery configs are sent again with the next collection
+		if id := messages[i].discoveryID; id != "" {
+			p.mu.Lock()
+			p.discovered[id] = true
+			p.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// messages returns the discovery configs not published yet, the JSON state
+// and the per-metric messages of a snapshot.
+func (p *MQTTPublisher) messages(snap *Snapshot) ([]mqttMessage, error) {
+	var messages []mqttMessage
+	if p.cfg.Discovery {
+		p.mu.Lock()
+		for _, sensor := range p.discoverySensors(snap) {
+			if p.discovered[sensor.objectID] {
+				continue
+			}
+			payload, err := json.Marshal(p.discoveryConfig(sensor))
+			if err != nil {
+				p.mu.Unlock()
+				return nil, err
+			}
+			topic := strings.Join([]string{p.cfg.DiscoveryPrefix, "sensor", p.cfg.NodeID, sensor.objectID, "config"}, "/")
+			// discovery configs are always retained so Home Assistant finds
+			// them after a restart
+			messages = append(messages, mqttMessage{topic: topic, payload: payload, retain: true, discoveryID: sensor.objectID})
+		}
+		p.mu.Unlock()
+	}
+
+	state, err := json.Marshal(newSnapshotResponse(snap, SnapshotFilter{}))
+	if err != nil {
+		return nil, err
+	}
+	messages = append(messages, mqttMessage{topic: p.topic("state"), payload: state, retain: p.cfg.Retain})
+
+	for i := range snap.Rows {
+		row := &snap.Rows[i]
+		for _, values := range []map[string]float64{row.Other, row.OtherPercent} {
+			for column, v := range values {
+				messages = append(messages, mqttMessage{
+					topic:   p.topic(append(mqttRowPath(row), mqttMetricName(column))...),
+					payload: []byte(strconv.FormatFloat(v, 'f', -1, 64)),
+					retain:  p.cfg.Retain,
+				})
+			}
+		}
+	}
+	return messages, nil
+}
+
+// mqttRowPath returns the topic levels of a row, e.g. ["core", "0", "3"].
+func mqttRowPath(row *TurbostatRow) []string {
+	switch row.Category {
+	case "package":
+		return []string{"package", mqttPackage(row)}
+	case "core":
+		return []string{"core", mqttPackage(row), row.Core}
+	case "cpu":
+		return []string{"cpu", row.CPU}
+	default:
+		return []string{"total"}
+	}
+}
+
+// mqttPackage returns the package of a row, turbostat leaves out the Package
+// column on single socket systems.
+func mqttPackage(row *TurbostatRow) string {
+	if row.Pkg == "" {
+		return "0"
+	}
+	return row.Pkg
+}
+
+// mqttMetricName follows the Prometheus type label, with a _percent suffix
+// so C1 and C1% don't share a topic.
+func mqttMetricName(column string) string {
+	name := sanitizeHeader(column)
+	if strings.Contains(column, "%") {
+		name += "_percent"
+	}
+	return name
+}
+
+type discoverySensor struct {
+	objectID    string
+	name        string
+	stateTopic  string
+	unit        string
+	deviceClass string
+}
+
+// discoverySensors returns the Home Assistant sensors of a snapshot: power
+// per package, temperature per core and the busy % of the whole system.
+func (p *MQTTPublisher) discoverySensors(snap *Snapshot) []discoverySensor {
+	var sensors []discoverySensor
+	seenCores := map[string]bool{}
+	for i := range snap.Rows {
+		row := &snap.Rows[i]
+		switch row.Category {
+		case "total":
+			if _, ok := row.OtherPercent["Busy%"]; ok {
+				sensors = append(sensors, discoverySensor{
+					objectID:   "busy",
+					name:       "Busy",
+					stateTopic: p.topic("total", mqttMetricName("Busy%")),
+					unit:       "%",
+				})
+			}
+		case "package":
+			if _, ok := row.Other["PkgWatt"]; ok {
+				pkg := mqttPackage(row)
+				sensors = append(sensors, discoverySensor{
+					objectID:    "package_" + pkg + "_power",
+					name:        "Package " + pkg + " power",
+					stateTopic:  p.topic("package", pkg, mqttMetricName("PkgWatt")),
+					unit:        "W",
+					deviceClass: "power",
+				})
+			}
+		case "core":
+			pkg := mqttPackage(row)
+			key := pkg + "/" + row.Core
+			if _, ok := row.Other["CoreTmp"]; ok && !seenCores[key] {
+				seenCores[key] = true
+				objectID := "core_" + row.Core + "_temperature"
+				name := "Core " + row.Core + " temperature"
+				if pkg != "0" {
+					objectID = "package_" + pkg + "_" + objectID
+					name = "Package " + pkg + " core " + row.Core + " temperature"
+				}
+				sensors = append(sensors, discoverySensor{
+					objectID:    objectID,
+					name:        name,
+					stateTopic:  p.topic("core", pkg, row.Core, mqttMetricName("CoreTmp")),
+					unit:        "°C",
+					deviceClass: "temperature",
+				})
+			}
+		}
+	}
+	return sensors
+}
+
+type discoveryDevice struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+	Model       string   `json:"model"`
+	SWVersion   string   `json:"sw_version,omitempty"`
+}
+
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	UnitOfMeasurement string          `json:"unit_of_measurement"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Device            discoveryDevice `json:"device"`
+}
+
+func (p *MQTTPublisher) discoveryConfig(sensor discoverySensor) discoveryConfig {
+	return discoveryConfig{
+		Name:              sensor.name,
+		UniqueID:          "turbostat_" + p.cfg.NodeID + "_" + sensor.objectID,
+		StateTopic:        sensor.stateTopic,
+		UnitOfMeasurement: sensor.unit,
+		DeviceClass:       sensor.deviceClass,
+		StateClass:        "measurement",
+		AvailabilityTopic: p.topic("status"),
+		Device: discoveryDevice{
+			Identifiers: []string{"turbostat_" + p.cfg.NodeID},
+			Name:        p.cfg.NodeID,
+			Model:       "turbostat-exporter",
+			SWVersion:   p.cfg.Version,
+		},
+	}
+}
+
+// Close marks the exporter offline and disconnects from the broker.
+func (p *MQTTPublisher) Close(ctx context.Context) {
+	if p.client.IsConnectionOpen() {
+		t := p.client.Publish(p.topic("status"), p.cfg.QoS, true, "offline")
+		select {
+		case <-t.Done():
+		case <-ctx.Done():
+		}
+	}
+	p.client.Disconnect(250)
+}
@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// mqttBrokerStub accepts one client and records its publications.
+type mqttBrokerStub struct {
+	listener net.Listener
+
+	// noAck drops QoS 1 publications without acknowledging them
+	noAck bool
+
+	mu        sync.Mutex
+	username  string
+	will      string
+	published map[string]*packets.PublishPacket
+}
+
+func newMQTTBrokerStub(t *testing.T) *mqttBrokerStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &mqttBrokerStub{listener: listener, published: map[string]*packets.PublishPacket{}}
+	go b.serve()
+	t.Cleanup(func() { listener.Close() })
+	return b
+}
+
+func (b *mqttBrokerStub) serve() {
+	conn, err := b.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			b.mu.Lock()
+			b.username = p.Username
+			b.will = p.WillTopic
+			b.mu.Unlock()
+			_ = packets.NewControlPacket(packets.Connack).Write(conn)
+		case *packets.PublishPacket:
+			b.mu.Lock()
+			b.published[p.TopicName] = p
+			b.mu.Unlock()
+			if p.Qos == 1 && !b.noAck {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				_ = ack.Write(conn)
+			}
+		case *packets.PingreqPacket:
+			_ = packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *mqttBrokerStub) get(topic string) *packets.PublishPacket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.published[topic]
+}
+
+func TestMQTTPublisher_PublishesStateAndDiscovery(t *testing.T) {
+	broker := newMQTTBrokerStub(t)
+
+	publisher, err := NewMQTTPublisher(MQTTConfig{
+		Broker:      "tcp://" + broker.listener.Addr().String(),
+		ClientID:    "test",
+		Username:    "user",
+		Password:    "pass",
+		TopicPrefix: "turbostat/prox",
+		QoS:         1,
+		Retain:      true,
+		Timeout:     time.Second,
+		Discovery:   true,
+		NodeID:      "prox",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	publisher.Connect()
+	go publisher.Run(ctx)
+
+	publisher.Record(&Snapshot{Timestamp: time.Now(), Duration: 5 * time.Second, Rows: []TurbostatRow{
+		{Category: "total", Other: map[string]float64{}, OtherPercent: map[string]float64{"Busy%": 6.57}},
+		{Category: "package", Other: map[string]float64{"PkgWatt": 10.6}, OtherPercent: map[string]float64{}},
+		{Category: "core", Core: "4", CPU: "2", Other: map[string]float64{"CoreTmp": 31}, OtherPercent: map[string]float64{}},
+	}})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for broker.get("turbostat/prox/core/0/4/coretmp") == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	broker.mu.Lock()
+	if broker.username != "user" || broker.will != "turbostat/prox/status" {
+		t.Errorf("expected credentials and last will, got %q and %q", broker.username, broker.will)
+	}
+	broker.mu.Unlock()
+
+	power := broker.get("turbostat/prox/package/0/pkgwatt")
+	if power == nil || string(power.Payload) != "10.6" || !power.Retain || power.Qos != 1 {
+		t.Fatalf("expected retained package power, got %v", power)
+	}
+	if busy := broker.get("turbostat/prox/total/busy_percent"); busy == nil || string(busy.Payload) != "6.57" {
+		t.Errorf("expected total busy %%, got %v", busy)
+	}
+	if state := broker.get("turbostat/prox/state"); state == nil || !json.Valid(state.Payload) {
+		t.Errorf("expected the JSON state, got %v", state)
+	}
+
+	for _, topic := range []string{
+		"homeassistant/sensor/prox/busy/config",
+		"homeassistant/sensor/prox/package_0_power/config",
+		"homeassistant/sensor/prox/core_4_temperature/config",
+	} {
+		config := broker.get(topic)
+		if config == nil || !config.Retain {
+			t.Errorf("expected a retained discovery config on %s", topic)
+			continue
+		}
+		var payload map[string]any
+		if err := json.Unmarshal(config.Payload, &payload); err != nil {
+			t.Errorf("invalid discovery config on %s: %v", topic, err)
+		}
+		if topic == "homeassistant/sensor/prox/package_0_power/config" &&
+			(payload["state_topic"] != "turbostat/prox/package/0/pkgwatt" || payload["device_class"] != "power" || payload["unit_of_measurement"] != "W") {
+			t.Errorf("unexpected package power config %v", payload)
+		}
+	}
+
+	publisher.Close(context.Background())
+	if status := broker.get("turbostat/prox/status"); status == nil || string(status.Payload) != "offline" {
+		t.Errorf("expected offline status after close, got %v", status)
+	}
+}
+
+func TestMQTTPublisher_RetriesFailedDiscovery(t *testing.T) {
+	broker := newMQTTBrokerStub(t)
+	broker.noAck = true
+
+	publisher, err := NewMQTTPublisher(MQTTConfig{
+		Broker:      "tcp://" + broker.listener.Addr().String(),
+		ClientID:    "test",
+		TopicPrefix: "turbostat/prox",
+		QoS:         1,
+		Timeout:     100 * time.Millisecond,
+		Discovery:   true,
+		NodeID:      "prox",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher.Connect()
+	defer func() {
+		// the offline status isn't acknowledged either
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		publisher.Close(ctx)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for !publisher.client.IsConnectionOpen() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	snap := &Snapshot{Timestamp: time.Now(), Rows: []TurbostatRow{
+		{Category: "package", Other: map[string]float64{"PkgWatt": 10.6}, OtherPercent: map[string]float64{}},
+	}}
+	if err := publisher.publish(snap); err == nil {
+		t.Fatal("expected the unacknowledged publication to fail")
+	}
+
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	if len(publisher.discovered) != 0 {
+		t.Errorf("expected failed discovery configs to be sent again, got %v", publisher.discovered)
+	}
+}
+
+func TestNewMQTTPublisher_RejectsInvalidQoS(t *testing.T) {
+	if _, err := NewMQTTPublisher(MQTTConfig{Broker: "tcp://localhost:1883", QoS: 3}); err == nil {
+		t.Error("expected an error for QoS 3")
+	}
+}
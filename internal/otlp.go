@@ -0,0 +1,358 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// OTLPConfig configures the OTLP metrics export.
+type OTLPConfig struct {
+	// Endpoint is the URL of the collector, e.g. "http://collector:4318" for
+	// HTTP or "http://collector:4317" for gRPC. "https" enables TLS.
+	Endpoint string
+	// Protocol is "http/protobuf" or "grpc".
+	Protocol string
+	Headers  map[string]string
+	Timeout  time.Duration
+	// Version is reported as service.version.
+	Version string
+}
+
+// otlpUnits maps the units of the column catalog to UCUM units used by OTel.
+var otlpUnits = map[string]string{
+	UnitMHz:     "MHz",
+	UnitPercent: "%",
+	UnitWatts:   "W",
+	UnitJoules:  "J",
+	UnitCelsius: "Cel",
+	UnitCount:   "{count}",
+	UnitRatio:   "1",
+	UnitSeconds: "s",
+	UnitMicros:  "us",
+}
+
+// OTLPExporter pushes the columns of every collection to an OTLP receiver.
+// Every column is a gauge named "turbostat.<column>" with the unit and
+// description of the column catalog. Energy is additionally exported as the
+// cumulative sum "turbostat.energy", integrating the average power of every
+// collection from the power columns or the *_J columns of turbostat --Joules
+// over the time since the previous collection.
+type OTLPExporter struct {
+	provider *sdkmetric.MeterProvider
+	meter    metric.Meter
+
+	mu          sync.Mutex
+	latest      *Snapshot
+	instruments map[string]metric.Float64ObservableGauge
+	// registration observes all instruments, it is replaced when new
+	// columns show up
+	registration metric.Registration
+	energy       map[energyKey]float64
+	// energyAt is the timestamp of the collection energy was last added for
+	energyAt time.Time
+	wake     chan struct{}
+}
+
+type energyKey struct {
+	domain, pkg string
+}
+
+func NewOTLPExporter(ctx context.Context, cfg OTLPConfig) (*OTLPExporter, error) {
+	exporter, err := newOTLPMetricExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(otlpResourceAttributes(cfg.Version)...))
+	if err != nil {
+		return nil, err
+	}
+
+	// Exports are triggered by collections with ForceFlush, the interval only
+	// matters if no collection happens for a long time.
+	reader := sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(24*time.Hour))
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+
+	e := &OTLPExporter{
+		provider:    provider,
+		meter:       provider.Meter("turbostat-exporter"),
+		instruments: map[string]metric.Float64ObservableGauge{},
+		energy:      map[energyKey]float64{},
+		wake:        make(chan struct{}, 1),
+	}
+
+	energy, err := e.meter.Float64ObservableCounter("turbostat.energy",
+		metric.WithUnit("J"),
+		metric.WithDescription("Energy consumed since the exporter started, per RAPL domain and package."))
+	if err != nil {
+		return nil, err
+	}
+	_, err = e.meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for k, v := range e.energy {
+			o.ObserveFloat64(energy, v, metric.WithAttributes(attribute.String("domain", k.domain), attribute.String("package", k.pkg)))
+		}
+		return nil
+	}, energy)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func newOTLPMetricExporter(ctx context.Context, cfg OTLPConfig) (sdkmetric.Exporter, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, expected a URL like http://collector:4318", cfg.Endpoint)
+	}
+
+	switch cfg.Protocol {
+	case "grpc":
+		opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(u.Host), otlpmetricgrpc.WithTimeout(cfg.Timeout)}
+		if u.Scheme != "https" {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(cfg.Headers))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	case "", "http/protobuf":
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/metrics"
+		}
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpointURL(u.String()), otlpmetrichttp.WithTimeout(cfg.Timeout)}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(cfg.Headers))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, use http/protobuf or grpc", cfg.Protocol)
+	}
+}
+
+// otlpResourceAttributes describes the host using OTel semantic conventions.
+func otlpResourceAttributes(version string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", "turbostat-exporter"),
+		attribute.String("service.version", version),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		attrs = append(attrs, attribute.String("host.name", hostname))
+	}
+
+	cpuinfo, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return attrs
+	}
+	defer cpuinfo.Close()
+
+	keys := map[string]string{
+		"model name": "host.cpu.model.name",
+		"vendor_id":  "host.cpu.vendor.id",
+		"cpu family": "host.cpu.family",
+		"model":      "host.cpu.model.id",
+		"stepping":   "host.cpu.stepping",
+	}
+	scanner := bufio.NewScanner(cpuinfo)
+	for scanner.Scan() && len(keys) > 0 {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if key, ok := keys[name]; ok {
+			attrs = append(attrs, attribute.String(key, strings.TrimSpace(value)))
+			delete(keys, name)
+		}
+	}
+	return attrs
+}
+
+// Record stores the snapshot of a collection and schedules its export.
+func (e *OTLPExporter) Record(snap *Snapshot) {
+	e.mu.Lock()
+	e.latest = snap
+	e.accumulateEnergy(snap)
+	var columns []string
+	for _, row := range snap.Rows {
+		for _, values := range []map[string]float64{row.Other, row.OtherPercent} {
+			for column := range values {
+				if _, ok := e.instruments[column]; !ok && !slices.Contains(columns, column) {
+					columns = append(columns, column)
+				}
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	// the SDK calls observe with its own locks held, so instruments are never
+	// created while holding e.mu
+	if len(columns) > 0 {
+		e.addInstruments(columns)
+	}
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// addInstruments creates gauges for new columns and replaces the callback
+// registration with one observing all gauges. It is only called by Record,
+// which is never called concurrently.
+func (e *OTLPExporter) addInstruments(columns []string) {
+	e.mu.Lock()
+	instruments := maps.Clone(e.instruments)
+	registration := e.registration
+	e.mu.Unlock()
+
+	for _, column := range columns {
+		gauge, err := e.newGauge(column)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to create OTLP instrument for column %s", column)
+			continue
+		}
+		instruments[column] = gauge
+	}
+
+	if registration != nil {
+		if err := registration.Unregister(); err != nil {
+			log.Warn().Err(err).Msg("Failed to unregister OTLP callback")
+		}
+	}
+	observables := make([]metric.Observable, 0, len(instruments))
+	for _, gauge := range instruments {
+		observables = append(observables, gauge)
+	}
+	registration, err := e.meter.RegisterCallback(e.observe, observables...)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to register OTLP callback")
+		registration = nil
+	}
+
+	e.mu.Lock()
+	e.instruments = instruments
+	e.registration = registration
+	e.mu.Unlock()
+}
+
+func (e *OTLPExporter) newGauge(column string) (metric.Float64ObservableGauge, error) {
+	info := LookupColumn(column)
+	opts := []metric.Float64ObservableGaugeOption{metric.WithDescription(info.Help)}
+	if unit, ok := otlpUnits[info.Unit]; ok {
+		opts = append(opts, metric.WithUnit(unit))
+	}
+	// like the Prometheus metrics, percent columns get their own names, so
+	// e.g. C1 and C1% don't clash
+	name := "turbostat." + sanitizeHeader(column)
+	if strings.Contains(column, "%") {
+		name += "_percent"
+	}
+	return e.meter.Float64ObservableGauge(name, opts...)
+}
+
+// observe reports the values of the latest snapshot.
+func (e *OTLPExporter) observe(_ context.Context, o metric.Observer) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.latest == nil {
+		return nil
+	}
+	for _, row := range e.latest.Rows {
+		attrs := []attribute.KeyValue{attribute.String("scope", row.Category)}
+		if row.Category != "total" {
+			attrs = append(attrs, attribute.String("package", row.Pkg))
+		}
+		if row.Category == "core" || row.Category == "cpu" {
+			attrs = append(attrs, attribute.String("core", row.Core))
+		}
+		if row.Category == "cpu" {
+			attrs = append(attrs, attribute.String("cpu", row.CPU))
+		}
+		opt := metric.WithAttributes(attrs...)
+
+		for _, values := range []map[string]float64{row.Other, row.OtherPercent} {
+			for column, v := range values {
+				if gauge, ok := e.instruments[column]; ok {
+					o.ObserveFloat64(gauge, v, opt)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// accumulateEnergy adds the energy of every package row of snap. turbostat
+// only measures for snap.Duration, e.g. 5s every 60s in background mode, so
+// the average power of the collection is integrated over the wall-clock time
+// since the previous one. e.mu must be held.
+func (e *OTLPExporter) accumulateEnergy(snap *Snapshot) {
+	elapsed := snap.Duration
+	if !e.energyAt.IsZero() && snap.Timestamp.After(e.energyAt) {
+		elapsed = snap.Timestamp.Sub(e.energyAt)
+	}
+	e.energyAt = snap.Timestamp
+
+	for _, row := range snap.Rows {
+		if row.Category != "package" {
+			continue
+		}
+		watts := map[string]float64{}
+		if snap.Duration > 0 {
+			for column, v := range row.Other {
+				if domain, ok := strings.CutSuffix(column, "_J"); ok {
+					watts[strings.ToLower(domain)] = v / snap.Duration.Seconds()
+				}
+			}
+		}
+		for column, v := range row.Other {
+			domain, ok := strings.CutSuffix(column, "Watt")
+			if !ok {
+				continue
+			}
+			domain = strings.ToLower(domain)
+			if _, ok := watts[domain]; !ok {
+				watts[domain] = v
+			}
+		}
+		for domain, w := range watts {
+			e.energy[energyKey{domain: domain, pkg: row.Pkg}] += w * elapsed.Seconds()
+		}
+	}
+}
+
+// Run exports recorded collections until ctx is done.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-e.wake:
+			if err := e.provider.ForceFlush(ctx); err != nil {
+				log.Error().Err(err).Msg("Failed to export metrics over OTLP")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Shutdown exports the latest collection and closes the connection.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return e.provider.Shutdown(ctx)
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPExporter_ExportsGaugesAndEnergy(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []*collectorpb.ExportMetricsServiceRequest
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Api-Key") != "secret" {
+			t.Error("expected the configured header")
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := &collectorpb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Errorf("expected an OTLP protobuf body: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	exporter, err := NewOTLPExporter(context.Background(), OTLPConfig{
+		Endpoint: server.URL,
+		Protocol: "http/protobuf",
+		Headers:  map[string]string{"X-Api-Key": "secret"},
+		Timeout:  time.Second,
+		Version:  "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := TurbostatRow{Category: "package", Pkg: "0", Other: map[string]float64{"PkgWatt": 10}, OtherPercent: map[string]float64{"Busy%": 25}}
+	// 5 s measurements a minute apart like the background mode
+	start := time.Now()
+	for i := range 2 {
+		exporter.Record(&Snapshot{Timestamp: start.Add(time.Duration(i) * time.Minute), Duration: 5 * time.Second, Rows: []TurbostatRow{row}})
+	}
+	if err := exporter.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) == 0 {
+		t.Fatal("expected an export request")
+	}
+	rm := requests[len(requests)-1].GetResourceMetrics()[0]
+
+	hasHost := false
+	for _, attr := range rm.GetResource().GetAttributes() {
+		if attr.GetKey() == "host.name" && attr.GetValue().GetStringValue() != "" {
+			hasHost = true
+		}
+	}
+	if !hasHost {
+		t.Error("expected the host.name resource attribute")
+	}
+
+	metrics := map[string]*metricspb.Metric{}
+	for _, sm := range rm.GetScopeMetrics() {
+		for _, m := range sm.GetMetrics() {
+			metrics[m.GetName()] = m
+		}
+	}
+
+	power := metrics["turbostat.pkgwatt"]
+	if power.GetUnit() != "W" || power.GetDescription() != "Package power consumption." || power.GetGauge() == nil {
+		t.Errorf("expected a gauge in W with the catalog description, got %v", power)
+	}
+	if busy := metrics["turbostat.busy_percent"]; busy.GetUnit() != "%" {
+		t.Errorf("expected a gauge in %%, got %v", busy)
+	}
+
+	energy := metrics["turbostat.energy"].GetSum()
+	if energy == nil || !energy.GetIsMonotonic() || energy.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Fatalf("expected a monotonic cumulative sum, got %v", metrics["turbostat.energy"])
+	}
+	// 10 W for the first 5 s measurement and the minute until the second one
+	if v := energy.GetDataPoints()[0].GetAsDouble(); v != 650 {
+		t.Errorf("expected 650 J, got %v", v)
+	}
+}
+
+func TestOTLPExporter_RejectsUnknownProtocol(t *testing.T) {
+	if _, err := NewOTLPExporter(context.Background(), OTLPConfig{Endpoint: "http://localhost:4318", Protocol: "http/json"}); err == nil {
+		t.Error("expected an error for an unsupported protocol")
+	}
+}
+
+func TestOTLPExporter_AccumulateEnergyFromJoules(t *testing.T) {
+	e := &OTLPExporter{energy: map[energyKey]float64{}}
+	start := time.Now()
+	row := TurbostatRow{Category: "package", Pkg: "0", Other: map[string]float64{"Pkg_J": 50, "RAMWatt": 2}}
+	for i := range 3 {
+		e.accumulateEnergy(&Snapshot{Timestamp: start.Add(time.Duration(i) * 10 * time.Second), Duration: 5 * time.Second, Rows: []TurbostatRow{row}})
+	}
+
+	// 50 J in 5 s are 10 W, integrated over 5 s + 2 * 10 s
+	if v := e.energy[energyKey{domain: "pkg", pkg: "0"}]; v != 250 {
+		t.Errorf("expected 250 J, got %v", v)
+	}
+	if v := e.energy[energyKey{domain: "ram", pkg: "0"}]; v != 50 {
+		t.Errorf("expected 50 J, got %v", v)
+	}
+}
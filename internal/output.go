@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// OutputFormats are the formats supported by WriteSnapshot.
+var OutputFormats = []string{"prom", "json", "csv"}
+
+// WriteSnapshot writes snap in the given format. "prom" writes the metrics
+// gathered from gatherer in the Prometheus text format, "json" the same
+// document as /api/v1/snapshot and "csv" one line per row.
+func WriteSnapshot(w io.Writer, format string, snap *Snapshot, gatherer prometheus.Gatherer) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(newSnapshotResponse(snap, SnapshotFilter{}))
+	case "csv":
+		return writeSnapshotCSV(w, snap)
+	default:
+		families, err := gatherer.Gather()
+		if err != nil {
+			return err
+		}
+		enc := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeTextPlain))
+		for _, mf := range families {
+			if err := enc.Encode(mf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// writeSnapshotCSV writes the rows of snap with the columns in turbostat
+// order. Columns a row doesn't have are left empty.
+func writeSnapshotCSV(w io.Writer, snap *Snapshot) error {
+	columns := []string{}
+	for _, h := range snap.Headers {
+		if !slices.Contains([]string{"Package", "Core", "CPU"}, h) {
+			columns = append(columns, h)
+		}
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(append([]string{"category", "package", "core", "cpu"}, columns...)); err != nil {
+		return err
+	}
+	for _, row := range snap.Rows {
+		record := []string{row.Category, row.Pkg, row.Core, row.CPU}
+		if row.Category == "total" {
+			record = []string{row.Category, "", "", ""}
+		}
+		for _, c := range columns {
+			v, ok := row.Other[c]
+			if !ok {
+				v, ok = row.OtherPercent[c]
+			}
+			if ok {
+				record = append(record, strconv.FormatFloat(v, 'f', -1, 64))
+			} else {
+				record = append(record, "")
+			}
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
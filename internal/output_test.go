@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteSnapshot_CSV(t *testing.T) {
+	headers := []string{"Core", "CPU", "Busy%", "CoreTmp"}
+	rows := [][]string{
+		{"-", "-", "2.00", "45"},
+		{"0", "0", "1.00", "40"},
+		{"0", "1", "3.00"},
+	}
+	snap := &Snapshot{Headers: headers, Rows: FlattenRows(NewTurbostatParser().ParseRowsSimple(headers, rows))}
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, "csv", snap, nil); err != nil {
+		t.Fatalf("expected csv output, got error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "category,package,core,cpu,Busy%,CoreTmp" {
+		t.Errorf("unexpected header line %q", lines[0])
+	}
+	if lines[1] != "total,,,,2,45" {
+		t.Errorf("unexpected total line %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[len(lines)-1], ",3,") {
+		t.Errorf("expected missing CoreTmp to be empty, got %q", lines[len(lines)-1])
+	}
+}
@@ -15,6 +15,8 @@ type TurbostatParser struct {
 	colParsers          []columnParseFunc
 	rowLengthToCategory map[int]string
 	categoryToRowLength map[string]int
+	// columnFilter decides which columns are stored, nil keeps all of them
+	columnFilter func(header string) bool
 }
 
 type columnParseFunc func(row *TurbostatRow, col string)
@@ -24,6 +26,17 @@ func NewTurbostatParser() *TurbostatParser {
 	return parser
 }
 
+// SetColumnFilter makes ParseRowSimple drop every column for which allowed
+// returns false.
+func (p *TurbostatParser) SetColumnFilter(allowed func(header string) bool) {
+	p.columnFilter = allowed
+}
+
+// ColumnAllowed reports whether the column filter keeps a column.
+func (p *TurbostatParser) ColumnAllowed(header string) bool {
+	return p.columnFilter == nil || p.columnFilter(header)
+}
+
 func (p *TurbostatParser) SetupColumnParsers(headers []string) {
 	if len(p.colParsers) > 0 {
 		// parsers are already setup
@@ -274,7 +287,7 @@ func (p *TurbostatParser) ParseRowSimple(category string, headers []string, row
 			packageResult = tr.CloneWithCategory("package")
 		}
 
-		if i < len(headers) {
+		if i < len(headers) && p.ColumnAllowed(headers[i]) {
 			key := headers[i]
 			if strings.Contains(key, "%") {
 				tr.OtherPercent[key] = val
@@ -334,10 +347,30 @@ func (p *TurbostatParser) ParseRowsSimple(headers []string, rows [][]string) map
 	return result
 }
 
+// FlattenRows collects the rows of all categories returned by ParseRowsSimple
+// into a single slice, ordered total, package, core, cpu.
+func FlattenRows(categorized map[string][]*TurbostatRow) []TurbostatRow {
+	rows := make([]TurbostatRow, 0)
+	for _, category := range []string{"total", "package", "core", "cpu"} {
+		for _, r := range categorized[category] {
+			rows = append(rows, *r)
+		}
+	}
+	return rows
+}
+
 // old?
 func ParseTurbostatOutput(raw string) ([]string, [][]string, error) {
+	headers, rows, _, err := ParseTurbostatOutputWithWarnings(raw)
+	return headers, rows, err
+}
+
+// ParseTurbostatOutputWithWarnings works like ParseTurbostatOutput but also
+// returns a description of every line it ignored.
+func ParseTurbostatOutputWithWarnings(raw string) ([]string, [][]string, []string, error) {
 	var headers []string
 	var rows [][]string
+	var warnings []string
 
 	lines := strings.Split(raw, "\n")
 	for _, line := range lines {
@@ -362,6 +395,7 @@ func ParseTurbostatOutput(raw string) ([]string, [][]string, error) {
 		if len(headers) == 0 {
 			if !isTurbostatHeaderLine(fields) {
 				log.Warn().Msgf("Ignoring unexpected line before turbostat header: %s", line)
+				warnings = append(warnings, fmt.Sprintf("ignored line before turbostat header: %s", line))
 				continue
 			}
 			headers = fields
@@ -374,7 +408,7 @@ func ParseTurbostatOutput(raw string) ([]string, [][]string, error) {
 		rows = append(rows, fields)
 	}
 	if len(headers) == 0 {
-		return nil, nil, fmt.Errorf("no headers found in turbostat output")
+		return nil, nil, warnings, fmt.Errorf("no headers found in turbostat output")
 	}
-	return headers, rows, nil
+	return headers, rows, warnings, nil
 }
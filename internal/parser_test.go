@@ -169,3 +169,20 @@ Core CPU Test
 		t.Errorf("expected 3 headers, got %d", len(headers))
 	}
 }
+
+func TestParseOutputWithWarnings_ReportsIgnoredLines(t *testing.T) {
+	headers, rows, warnings, err := ParseTurbostatOutputWithWarnings(`turbostat: no access to /dev/cpu/0/msr
+Core	CPU	Avg_MHz
+-	-	125
+`)
+
+	if err != nil {
+		t.Fatalf("expected parsing turbostat output to succeed, got error: %v", err)
+	}
+	if len(headers) != 3 || len(rows) != 1 {
+		t.Errorf("expected 3 headers and 1 row, got %d headers and %d rows", len(headers), len(rows))
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "/dev/cpu/0/msr") {
+		t.Errorf("expected one warning about the ignored line, got %v", warnings)
+	}
+}
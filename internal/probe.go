@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+// ProbeLimits bounds what a scraper may request from /probe.
+type ProbeLimits struct {
+	MinSeconds int
+	MaxSeconds int
+	// AllowedColumns limits show/hide. If empty, every column of the column
+	// catalog and every turbostat column group is allowed.
+	AllowedColumns []string
+	// MaxConcurrent is the number of probes allowed to run turbostat at the
+	// same time. Further probes wait for a free slot.
+	MaxConcurrent int
+}
+
+func (l ProbeLimits) columnAllowed(name string) bool {
+	if !columnNamePattern.MatchString(name) {
+		return false
+	}
+	if len(l.AllowedColumns) > 0 {
+		return slices.Contains(l.AllowedColumns, name)
+	}
+	info := LookupColumn(name)
+	return info.Help != "" || slices.Contains(turbostatGroups, name)
+}
+
+// ProbeRunFunc runs turbostat for the given number of seconds and returns
+// its combined output.
+type ProbeRunFunc func(ctx context.Context, seconds int, opts TurbostatOptions) (string, error)
+
+type probeRequest struct {
+	seconds int
+	opts    TurbostatOptions
+}
+
+func (l ProbeLimits) parseRequest(query map[string][]string, defaultSeconds int) (probeRequest, error) {
+	req := probeRequest{seconds: defaultSeconds}
+
+	if v := firstValue(query, "seconds"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("seconds must be an integer")
+		}
+		req.seconds = seconds
+	}
+	if req.seconds < l.MinSeconds || req.seconds > l.MaxSeconds {
+		return req, fmt.Errorf("seconds must be between %d and %d", l.MinSeconds, l.MaxSeconds)
+	}
+
+	for _, param := range []string{"show", "hide"} {
+		for _, v := range query[param] {
+			for column := range strings.SplitSeq(v, ",") {
+				if column == "" {
+					continue
+				}
+				if !l.columnAllowed(column) {
+					return req, fmt.Errorf("column %q is not allowed", column)
+				}
+				if param == "show" {
+					req.opts.Show = append(req.opts.Show, column)
+				} else {
+					req.opts.Hide = append(req.opts.Hide, column)
+				}
+			}
+		}
+	}
+
+	if v := firstValue(query, "cpu"); v != "" {
+		cpus, err := ParseCPUList(v)
+		if err != nil {
+			return req, err
+		}
+		req.opts.CPUs = FormatCPUList(cpus)
+	}
+
+	return req, nil
+}
+
+func firstValue(query map[string][]string, key string) string {
+	if v := query[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// FormatCPUList renders cpus in the form turbostat expects. Consecutive CPUs
+// are joined to ranges like "0-63", a list of single CPUs of a large range
+// would exceed the maximum length of an argument.
+func FormatCPUList(cpus []int) string {
+	cpus = slices.Compact(slices.Sorted(slices.Values(cpus)))
+	var parts []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, strconv.Itoa(cpus[i])+"-"+strconv.Itoa(cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// ExporterFactory creates a TurbostatExporter registered with reg.
+type ExporterFactory func(reg prometheus.Registerer) *TurbostatExporter
+
+// ProbeHandler runs turbostat with the parameters of the request and answers
+// with the resulting metrics. Every probe uses its own parser and registry, so
+// probes with different columns don't interfere with each other or /metrics.
+func ProbeHandler(run ProbeRunFunc, limits ProbeLimits, defaultSeconds int, defaults TurbostatOptions, newExporter ExporterFactory) http.Handler {
+	slots := make(chan struct{}, max(limits.MaxConcurrent, 1))
+	defaultSeconds = min(max(defaultSeconds, limits.MinSeconds), limits.MaxSeconds)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := limits.parseRequest(r.URL.Query(), defaultSeconds)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// a request may only narrow the configured columns and CPUs
+		opts, err := defaults.Narrow(req.opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-r.Context().Done():
+			return
+		}
+
+		content, err := run(r.Context(), req.seconds, opts)
+		if err != nil {
+			log.Error().Err(err).Msg("Probe failed to run turbostat")
+			http.Error(w, "failed to run turbostat", http.StatusInternalServerError)
+			return
+		}
+
+		headers, rows, err := ParseTurbostatOutput(content)
+		if err != nil {
+			log.Error().Err(err).Msg("Probe failed to parse turbostat output")
+			http.Error(w, "failed to parse turbostat output", http.StatusInternalServerError)
+			return
+		}
+
+		registry := prometheus.NewRegistry()
+		exporter := newExporter(registry)
+		parser := NewTurbostatParser()
+		parser.SetColumnFilter(KeepCustomColumns(exporter.CustomCounters(), func(header string) bool {
+			return defaults.ColumnAllowed(header) && req.opts.ColumnAllowed(header)
+		}))
+		exporter.Update(FlattenRows(parser.ParseRowsSimple(headers, rows)))
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestProbeHandler(t *testing.T) {
+	content, err := os.ReadFile("../data/prox.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotSeconds int
+	var gotOpts TurbostatOptions
+	run := func(_ context.Context, seconds int, opts TurbostatOptions) (string, error) {
+		gotSeconds = seconds
+		gotOpts = opts
+		return string(content), nil
+	}
+	h := ProbeHandler(run, ProbeLimits{MinSeconds: 1, MaxSeconds: 30, MaxConcurrent: 1}, 5, TurbostatOptions{CPUs: "0"}, func(reg prometheus.Registerer) *TurbostatExporter {
+		return NewTurbostatExporterWithRegisterer(prefixed(reg))
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?seconds=1&show=PkgWatt,Busy%25&hide=idle&cpu=0-2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if gotSeconds != 1 {
+		t.Errorf("expected 1 second, got %d", gotSeconds)
+	}
+	// the request can't widen the configured CPU 0
+	wantArgs := []string{"--quiet", "--show", "PkgWatt,Busy%", "--hide", "idle", "--cpu", "0"}
+	if args := gotOpts.Args(); !slices.Equal(args, wantArgs) {
+		t.Errorf("expected args %v, got %v", wantArgs, args)
+	}
+	if !strings.Contains(rec.Body.String(), `turbostat_total{type="pkgwatt"} 10.6`) {
+		t.Errorf("expected total package watts in probe output")
+	}
+
+	for _, query := range []string{
+		"seconds=31",
+		"seconds=0",
+		"show=Foo",
+		"show=Busy%25%3Breboot",
+		"cpu=1-x",
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	for _, tc := range []struct {
+		cpus []int
+		want string
+	}{
+		{[]int{0, 1, 2}, "0-2"},
+		{[]int{5, 0, 2, 3, 4, 2}, "0,2-5"},
+		{[]int{7}, "7"},
+		{nil, ""},
+	} {
+		if got := FormatCPUList(tc.cpus); got != tc.want {
+			t.Errorf("FormatCPUList(%v): expected %q, got %q", tc.cpus, tc.want, got)
+		}
+	}
+
+	// the largest accepted range stays a short argument
+	cpus, err := ParseCPUList("0-65535")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FormatCPUList(cpus); got != "0-65535" {
+		t.Errorf("expected the range to be kept, got %d bytes", len(got))
+	}
+}
+
+func TestProbeHandler_KeepsCustomColumns(t *testing.T) {
+	content := "Package\tCore\tCPU\tBusy%\tPkgWatt\tSMIcount\n" +
+		"-\t-\t-\t2.00\t10.00\t7\n" +
+		"0\t0\t0\t2.00\t10.00\t7\n"
+	run := func(context.Context, int, TurbostatOptions) (string, error) { return content, nil }
+	newExporter := func(reg prometheus.Registerer) *TurbostatExporter {
+		reg = prefixed(reg)
+		e := NewTurbostatExporterWithRegisterer(reg)
+		e.AddCustomCounters(reg, []CustomCounter{{MSR: "0x34", Scope: "cpu", Column: "SMIcount", Metric: "smi_count", Help: "SMIs."}})
+		return e
+	}
+	h := ProbeHandler(run, ProbeLimits{MinSeconds: 1, MaxSeconds: 30, MaxConcurrent: 1}, 5, TurbostatOptions{}, newExporter)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?show=PkgWatt", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `turbostat_smi_count{core="0",cpu="0",package="0"} 7`) {
+		t.Errorf("expected the custom counter despite show, got:\n%s", body)
+	}
+	if strings.Contains(body, `type="busy"`) {
+		t.Errorf("expected Busy%% to be filtered by show")
+	}
+}
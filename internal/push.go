@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+)
+
+// PushBatch is the metrics of one collection.
+type PushBatch struct {
+	Timestamp time.Time
+	Families  []*dto.MetricFamily
+	// Snapshot holds the parsed rows for targets not based on the
+	// Prometheus metrics.
+	Snapshot *Snapshot
+}
+
+// PushTarget sends collections to a remote system.
+type PushTarget interface {
+	Name() string
+	// Push sends all batches, oldest first. Errors wrapped with
+	// PermanentError are not retried.
+	Push(ctx context.Context, batches []PushBatch) error
+}
+
+// PushDeleter is implemented by targets which can remove the pushed metrics
+// again when the exporter shuts down.
+type PushDeleter interface {
+	Delete(ctx context.Context) error
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// PermanentError marks err as not worth retrying, e.g. a rejected request.
+func PermanentError(err error) error {
+	return permanentError{err: err}
+}
+
+// PushAuth authenticates requests to push targets. BearerToken takes
+// precedence over basic auth.
+type PushAuth struct {
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+// Client returns an HTTP client adding the credentials to every request.
+func (a PushAuth) Client(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: authTransport{auth: a, next: http.DefaultTransport}}
+}
+
+type authTransport struct {
+	auth PushAuth
+	next http.RoundTripper
+}
+
+func (t authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	switch {
+	case t.auth.BearerToken != "":
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+t.auth.BearerToken)
+	case t.auth.Username != "":
+		r = r.Clone(r.Context())
+		r.SetBasicAuth(t.auth.Username, t.auth.Password)
+	}
+	return t.next.RoundTrip(r)
+}
+
+// PushOptions configures buffering and retries of a Pusher.
+type PushOptions struct {
+	// BufferSize is the number of collections kept while the target is
+	// unreachable. The oldest collections are dropped first.
+	BufferSize int
+	// MaxRetries is the number of retries of a failed push before the
+	// collections are kept for the next attempt.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	Registerer   prometheus.Registerer
+}
+
+// Pusher sends every collection to a PushTarget in the background, so a slow
+// or unreachable target never delays collections.
+type Pusher struct {
+	target   PushTarget
+	gatherer prometheus.Gatherer
+	opts     PushOptions
+
+	mu      sync.Mutex
+	pending []PushBatch
+	wake    chan struct{}
+	stopped chan struct{}
+
+	failures prometheus.Counter
+	dropped  prometheus.Counter
+}
+
+// NewPusher pushes the metrics of gatherer to target. gatherer may be nil for
+// targets only using the snapshot of a batch.
+func NewPusher(target PushTarget, gatherer prometheus.Gatherer, opts PushOptions) *Pusher {
+	opts.BufferSize = max(opts.BufferSize, 1)
+	p := &Pusher{
+		target:   target,
+		gatherer: gatherer,
+		opts:     opts,
+		wake:     make(chan struct{}, 1),
+		stopped:  make(chan struct{}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "exporter_push_failures_total",
+			Help:        "Failed attempts to push collections, including retries.",
+			ConstLabels: prometheus.Labels{"target": target.Name()},
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "exporter_push_dropped_collections_total",
+			Help:        "Collections dropped because the push buffer was full or the target rejected them.",
+			ConstLabels: prometheus.Labels{"target": target.Name()},
+		}),
+	}
+	if opts.Registerer != nil {
+		opts.Registerer.MustRegister(p.failures, p.dropped)
+	}
+	return p
+}
+
+// Enqueue gathers the current metrics and schedules them to be pushed with
+// the timestamp of the collection snap.
+func (p *Pusher) Enqueue(snap *Snapshot) {
+	batch := PushBatch{Timestamp: snap.Timestamp, Snapshot: snap}
+	if p.gatherer != nil {
+		families, err := p.gatherer.Gather()
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to gather metrics for %s", p.target.Name())
+			return
+		}
+		batch.Families = families
+	}
+
+	p.mu.Lock()
+	p.pending = append(p.pending, batch)
+	p.trimLocked()
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pusher) trimLocked() {
+	if over := len(p.pending) - p.opts.BufferSize; over > 0 {
+		p.dropped.Add(float64(over))
+		log.Debug().Msgf("Push buffer of %s is full, dropping %d collections", p.target.Name(), over)
+		// copy, so the backing array doesn't keep the dropped batches alive
+		p.pending = slices.Clone(p.pending[over:])
+	}
+}
+
+// Run pushes enqueued collections until ctx is done.
+func (p *Pusher) Run(ctx context.Context) {
+	defer close(p.stopped)
+	for {
+		select {
+		case <-p.wake:
+			p.flush(ctx, p.opts.MaxRetries)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flush pushes all pending collections. Collections which could not be
+// pushed after retries are kept for the next flush.
+func (p *Pusher) flush(ctx context.Context, retries int) {
+	p.mu.Lock()
+	batches := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+	if len(batches) == 0 {
+		return
+	}
+
+	backoff := p.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := p.target.Push(ctx, batches)
+		if err == nil {
+			return
+		}
+		p.failures.Inc()
+
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			log.Error().Err(err).Msgf("%s rejected %d collections, dropping them", p.target.Name(), len(batches))
+			p.dropped.Add(float64(len(batches)))
+			return
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			log.Error().Err(err).Msgf("Failed to push %d collections to %s, keeping them for the next attempt", len(batches), p.target.Name())
+			p.mu.Lock()
+			p.pending = append(batches, p.pending...)
+			p.trimLocked()
+			p.mu.Unlock()
+			return
+		}
+
+		log.Warn().Err(err).Msgf("Failed to push to %s, retrying in %s", p.target.Name(), backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+	}
+}
+
+// Close waits for Run to return and makes a last attempt to push pending
+// collections. If deleteMetrics is set and the target supports it, the pushed
+// metrics are removed from the target instead.
+func (p *Pusher) Close(ctx context.Context, deleteMetrics bool) {
+	<-p.stopped
+
+	deleter, ok := p.target.(PushDeleter)
+	if !ok || !deleteMetrics {
+		p.flush(ctx, 0)
+		return
+	}
+	if err := deleter.Delete(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to delete metrics from %s", p.target.Name())
+		return
+	}
+	log.Info().Msgf("Deleted metrics from %s", p.target.Name())
+}
+
+// TurbostatGatherer only returns the metric families of g starting with
+// prefix, leaving out e.g. the go_* and process_* metrics of the default
+// registry.
+func TurbostatGatherer(g prometheus.Gatherer, prefix string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		res := families[:0]
+		for _, mf := range families {
+			if strings.HasPrefix(mf.GetName(), prefix) {
+				res = append(res, mf)
+			}
+		}
+		return res, err
+	})
+}
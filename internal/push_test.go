@@ -0,0 +1,227 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func pushTestRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+	exporter.Update([]TurbostatRow{{Category: "total", Other: map[string]float64{"PkgWatt": 12.5, "CorWatt": 4}, OtherPercent: map[string]float64{}}})
+	return registry
+}
+
+// decodeWriteRequest returns the labels of every series of a remote write body.
+func decodeWriteRequest(t *testing.T, body []byte) []map[string]string {
+	t.Helper()
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("expected snappy body: %v", err)
+	}
+
+	fields := func(b []byte, fn func(num protowire.Number, v []byte)) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			b = b[n:]
+			if typ != protowire.BytesType {
+				n = protowire.ConsumeFieldValue(num, typ, b)
+				b = b[n:]
+				continue
+			}
+			v, n := protowire.ConsumeBytes(b)
+			b = b[n:]
+			fn(num, v)
+		}
+	}
+
+	var series []map[string]string
+	fields(raw, func(_ protowire.Number, ts []byte) {
+		labels := map[string]string{}
+		fields(ts, func(num protowire.Number, v []byte) {
+			if num != 1 {
+				return
+			}
+			var name string
+			fields(v, func(num protowire.Number, s []byte) {
+				if num == 1 {
+					name = string(s)
+				} else {
+					labels[name] = string(s)
+				}
+			})
+		})
+		series = append(series, labels)
+	})
+	return series
+}
+
+func TestPusher_RemoteWriteRetriesAndBuffers(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		series   []map[string]string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		series = decodeWriteRequest(t, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	target := NewRemoteWriteTarget(server.URL, map[string]string{"job": "turbostat", "instance": "bench-1"}, PushAuth{BearerToken: "secret"}, time.Second)
+	pusher := NewPusher(target, pushTestRegistry(), PushOptions{BufferSize: 10, MaxRetries: 2, RetryBackoff: time.Millisecond})
+
+	// both collections are sent together after the first attempt failed
+	pusher.Enqueue(&Snapshot{Timestamp: time.Now()})
+	pusher.Enqueue(&Snapshot{Timestamp: time.Now()})
+	pusher.flush(context.Background(), 2)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 {
+		t.Errorf("expected one retry, got %d requests", requests)
+	}
+	if len(series) != 4 {
+		t.Fatalf("expected 2 series for each of 2 collections, got %d", len(series))
+	}
+	for _, s := range series {
+		if s["__name__"] != "turbostat_total" || s["job"] != "turbostat" || s["instance"] != "bench-1" {
+			t.Errorf("unexpected labels %v", s)
+		}
+	}
+	if len(pusher.pending) != 0 {
+		t.Errorf("expected no pending collections, got %d", len(pusher.pending))
+	}
+}
+
+func TestPusher_KeepsCollectionsWhenUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	target := NewRemoteWriteTarget(server.URL, nil, PushAuth{}, time.Second)
+	pusher := NewPusher(target, pushTestRegistry(), PushOptions{BufferSize: 2, RetryBackoff: time.Millisecond})
+	for range 3 {
+		pusher.Enqueue(&Snapshot{Timestamp: time.Now()})
+	}
+	pusher.flush(context.Background(), 0)
+
+	if len(pusher.pending) != 2 {
+		t.Errorf("expected the 2 newest collections to be buffered, got %d", len(pusher.pending))
+	}
+}
+
+func TestPusher_PushgatewayDeletesOnClose(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		methods []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/metrics/job/turbostat/instance/bench-1" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			t.Error("expected basic auth credentials")
+		}
+		methods = append(methods, r.Method)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	target := NewPushgatewayTarget(server.URL, "turbostat", "bench-1", PushAuth{Username: "user", Password: "pass"}, time.Second)
+	pusher := NewPusher(target, pushTestRegistry(), PushOptions{BufferSize: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go pusher.Run(ctx)
+	pusher.Enqueue(&Snapshot{Timestamp: time.Now()})
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(methods)
+		mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	pusher.Close(context.Background(), true)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(methods) != 2 || methods[0] != http.MethodPut || methods[1] != http.MethodDelete {
+		t.Errorf("expected PUT followed by DELETE, got %v", methods)
+	}
+}
+
+func TestPushgatewayTarget_PermanentErrors(t *testing.T) {
+	status := http.StatusBadRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "pushed metrics are invalid or inconsistent with existing metrics", status)
+	}))
+	defer server.Close()
+
+	target := NewPushgatewayTarget(server.URL, "turbostat", "bench-1", PushAuth{}, time.Second)
+	families, err := pushTestRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := []PushBatch{{Families: families}}
+
+	err = target.Push(context.Background(), batches)
+	if _, ok := err.(permanentError); !ok {
+		t.Errorf("expected a rejected push to be permanent, got %v", err)
+	}
+
+	for _, status = range []int{http.StatusTooManyRequests, http.StatusBadGateway} {
+		err = target.Push(context.Background(), batches)
+		if _, ok := err.(permanentError); ok || err == nil {
+			t.Errorf("%d: expected a retryable error, got %v", status, err)
+		}
+	}
+}
+
+func TestPushgatewayTarget_DeleteUsesContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	target := NewPushgatewayTarget(server.URL, "turbostat", "bench-1", PushAuth{}, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := target.Delete(ctx); err == nil {
+		t.Errorf("expected the cancelled delete to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the delete to stop with its context, took %s", elapsed)
+	}
+}
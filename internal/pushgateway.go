@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// PushgatewayTarget replaces the metrics of a job/instance grouping key on a
+// Pushgateway with every push.
+type PushgatewayTarget struct {
+	url      string
+	job      string
+	instance string
+	client   push.HTTPDoer
+}
+
+func NewPushgatewayTarget(url, job, instance string, auth PushAuth, timeout time.Duration) *PushgatewayTarget {
+	return &PushgatewayTarget{url: url, job: job, instance: instance, client: auth.Client(timeout)}
+}
+
+func (t *PushgatewayTarget) Name() string {
+	return "pushgateway"
+}
+
+func (t *PushgatewayTarget) pusher(client push.HTTPDoer, families []*dto.MetricFamily) *push.Pusher {
+	return push.New(t.url, t.job).
+		Grouping("instance", t.instance).
+		Client(client).
+		Gatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil }))
+}
+
+// Push sends the latest batch. The Pushgateway only keeps the last push of a
+// grouping key, so older buffered batches are skipped.
+func (t *PushgatewayTarget) Push(ctx context.Context, batches []PushBatch) error {
+	client := &pushgatewayClient{ctx: ctx, client: t.client}
+	return client.result(t.pusher(client, batches[len(batches)-1].Families).PushContext(ctx))
+}
+
+// Delete removes the grouping key from the Pushgateway, so metrics of a host
+// which is gone don't linger forever.
+func (t *PushgatewayTarget) Delete(ctx context.Context) error {
+	client := &pushgatewayClient{ctx: ctx, client: t.client}
+	return client.result(t.pusher(client, nil).Delete())
+}
+
+// pushgatewayClient sends the requests of a push.Pusher with ctx, which
+// Pusher.Delete doesn't take, and records the status of the response, which
+// the errors of the Pusher only contain as text.
+type pushgatewayClient struct {
+	ctx    context.Context
+	client push.HTTPDoer
+	status int
+}
+
+func (c *pushgatewayClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req.WithContext(c.ctx))
+	if err == nil {
+		c.status = resp.StatusCode
+	}
+	return resp, err
+}
+
+// result marks err as permanent if the Pushgateway rejected the request,
+// e.g. because of inconsistent label sets. Rate limiting is retried.
+func (c *pushgatewayClient) result(err error) error {
+	if err != nil && c.status >= 400 && c.status < 500 && c.status != http.StatusTooManyRequests {
+		return PermanentError(err)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultMetricPrefix starts the names of all metrics of the exporter unless
+// another prefix is configured, see WrapRegisterer.
+const DefaultMetricPrefix = "turbostat_"
+
+// reservedLabels are set by the exporter itself and can't be constant.
+var reservedLabels = []string{"type", "package", "core", "cpu", "level", "le", "quantile", "instance", "target", "reason"}
+
+var (
+	metricPrefixPattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// WrapRegisterer returns a registerer adding prefix to the names and labels
+// to every metric registered with it. The metrics of the exporter are named
+// without prefix, e.g. "packages", and always registered through it.
+func WrapRegisterer(reg prometheus.Registerer, prefix string, labels prometheus.Labels) prometheus.Registerer {
+	if len(labels) > 0 {
+		reg = prometheus.WrapRegistererWith(labels, reg)
+	}
+	return prometheus.WrapRegistererWithPrefix(prefix, reg)
+}
+
+// ParseMetricPrefix validates a prefix replacing "turbostat_", e.g. to run
+// two exporters side by side. A missing trailing underscore is added.
+func ParseMetricPrefix(prefix string) (string, error) {
+	if !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	if !metricPrefixPattern.MatchString(prefix) {
+		return "", fmt.Errorf("invalid metric prefix %q", prefix)
+	}
+	return prefix, nil
+}
+
+// ParseConstLabels parses comma separated name=value pairs. Values may
+// reference environment variables like $NODE_NAME or ${NODE_NAME}, e.g. set
+// by the Kubernetes downward API.
+func ParseConstLabels(val string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	for pair := range strings.SplitSeq(val, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found {
+			return nil, fmt.Errorf("label %q must be name=value", pair)
+		}
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		if slices.Contains(reservedLabels, name) {
+			return nil, fmt.Errorf("label %s is used by the metrics of the exporter", name)
+		}
+		value = os.ExpandEnv(strings.TrimSpace(value))
+		if value == "" {
+			// Prometheus treats an empty label like a missing one
+			return nil, fmt.Errorf("label %s has an empty value, is the referenced environment variable set?", name)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
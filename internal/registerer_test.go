@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseConstLabels(t *testing.T) {
+	t.Setenv("NODE_NAME", "node-7")
+	t.Setenv("RACK", "r1")
+	labels, err := ParseConstLabels("node=$NODE_NAME, cluster=prod,rack=${RACK}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels["node"] != "node-7" || labels["cluster"] != "prod" || labels["rack"] != "r1" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+
+	for _, invalid := range []string{"node", "1node=a", "__name__=a", "cpu=0", "node=$UNSET_NODE_NAME"} {
+		if _, err := ParseConstLabels(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestWrapRegisterer(t *testing.T) {
+	prefix, err := ParseMetricPrefix("rapl")
+	if err != nil || prefix != "rapl_" {
+		t.Fatalf("expected rapl_, got %q (%v)", prefix, err)
+	}
+	if _, err := ParseMetricPrefix("1rapl"); err == nil {
+		t.Error("expected an invalid prefix to be rejected")
+	}
+
+	registry := prometheus.NewRegistry()
+	reg := WrapRegisterer(registry, prefix, prometheus.Labels{"node": "node-7"})
+	exporter := NewTurbostatExporterWithRegisterer(reg)
+	exporter.SetSeriesLimits(reg, SeriesLimits{})
+	exporter.Update([]TurbostatRow{
+		{Category: "total", Other: map[string]float64{"PkgWatt": 20}, OtherPercent: map[string]float64{}},
+	})
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "other_metric", Help: "Not renamed."})
+	registry.MustRegister(other)
+
+	expected := `
+# HELP other_metric Not renamed.
+# TYPE other_metric gauge
+other_metric 0
+# HELP rapl_series_limit_exceeded 1 if the last collection exceeded a series limit and levels were dropped, 0 otherwise.
+# TYPE rapl_series_limit_exceeded gauge
+rapl_series_limit_exceeded{node="node-7"} 0
+# HELP rapl_total Metrics for the whole system. First line in output.
+# TYPE rapl_total gauge
+rapl_total{node="node-7",type="pkgwatt"} 20
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "rapl_total", "rapl_series_limit_exceeded", "other_metric"); err != nil {
+		t.Error(err)
+	}
+
+	// outputs only take the metrics with the configured prefix
+	families, err := TurbostatGatherer(registry, prefix).Gather()
+	if err != nil || len(families) != 3 {
+		t.Errorf("expected only the rapl_ metrics, got %v (%v)", families, err)
+	}
+
+	// the exporter can be dropped with the wrapped registerer
+	exporter.Unregister()
+	if n, _ := testutil.GatherAndCount(registry, "rapl_total", "rapl_series_limit_exceeded"); n != 0 {
+		t.Errorf("expected the exporter metrics to be unregistered, got %d series", n)
+	}
+}
+
+// prefixed registers metrics of tests with the default prefix like the
+// exporter does.
+func prefixed(reg prometheus.Registerer) prometheus.Registerer {
+	return WrapRegisterer(reg, DefaultMetricPrefix, nil)
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteTarget sends collections to a Prometheus remote-write (1.0)
+// endpoint as snappy compressed protobuf, every sample with the timestamp of
+// its collection.
+type RemoteWriteTarget struct {
+	url    string
+	labels map[string]string
+	client *http.Client
+}
+
+// NewRemoteWriteTarget adds labels, e.g. job and instance, to every series
+// unless the series already has a label of that name.
+func NewRemoteWriteTarget(url string, labels map[string]string, auth PushAuth, timeout time.Duration) *RemoteWriteTarget {
+	return &RemoteWriteTarget{url: url, labels: labels, client: auth.Client(timeout)}
+}
+
+func (t *RemoteWriteTarget) Name() string {
+	return "remote_write"
+}
+
+// Push sends all batches in one request.
+func (t *RemoteWriteTarget) Push(ctx context.Context, batches []PushBatch) error {
+	body := snappy.Encode(nil, t.encode(batches))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return PermanentError(err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote write answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	// like Prometheus, only retry server errors and rate limiting
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return PermanentError(err)
+}
+
+type remoteLabel struct {
+	name, value string
+}
+
+// encode builds a prometheus.WriteRequest protobuf message.
+func (t *RemoteWriteTarget) encode(batches []PushBatch) []byte {
+	var req []byte
+	for _, batch := range batches {
+		ts := batch.Timestamp.UnixMilli()
+		for _, mf := range batch.Families {
+			for _, m := range mf.GetMetric() {
+				for _, s := range flattenMetric(mf, m) {
+					var series []byte
+					for _, l := range t.seriesLabels(s.name, m.GetLabel(), s.extra) {
+						var label []byte
+						label = protowire.AppendTag(label, 1, protowire.BytesType)
+						label = protowire.AppendString(label, l.name)
+						label = protowire.AppendTag(label, 2, protowire.BytesType)
+						label = protowire.AppendString(label, l.value)
+						series = protowire.AppendTag(series, 1, protowire.BytesType)
+						series = protowire.AppendBytes(series, label)
+					}
+					series = protowire.AppendTag(series, 2, protowire.BytesType)
+					series = protowire.AppendBytes(series, encodeSample(s.value, ts))
+					req = protowire.AppendTag(req, 1, protowire.BytesType)
+					req = protowire.AppendBytes(req, series)
+				}
+			}
+		}
+	}
+	return req
+}
+
+func encodeSample(value float64, timestamp int64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(value))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(timestamp))
+	return b
+}
+
+// seriesLabels returns the labels of a series sorted by name, as remote write
+// requires.
+func (t *RemoteWriteTarget) seriesLabels(name string, pairs []*dto.LabelPair, extra *remoteLabel) []remoteLabel {
+	labels := []remoteLabel{{name: "__name__", value: name}}
+	for _, l := range pairs {
+		labels = append(labels, remoteLabel{name: l.GetName(), value: l.GetValue()})
+	}
+	if extra != nil {
+		labels = append(labels, *extra)
+	}
+	for name, value := range t.labels {
+		if !slices.ContainsFunc(labels, func(l remoteLabel) bool { return l.name == name }) {
+			labels = append(labels, remoteLabel{name: name, value: value})
+		}
+	}
+	slices.SortFunc(labels, func(a, b remoteLabel) int { return strings.Compare(a.name, b.name) })
+	return labels
+}
+
+type flatSample struct {
+	name  string
+	extra *remoteLabel
+	value float64
+}
+
+// flattenMetric splits a metric into the series of the classic Prometheus
+// data model, e.g. _bucket, _sum and _count for histograms.
+func flattenMetric(mf *dto.MetricFamily, m *dto.Metric) []flatSample {
+	name := mf.GetName()
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		return []flatSample{{name: name, value: m.GetCounter().GetValue()}}
+	case dto.MetricType_GAUGE:
+		return []flatSample{{name: name, value: m.GetGauge().GetValue()}}
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		res := []flatSample{
+			{name: name + "_sum", value: s.GetSampleSum()},
+			{name: name + "_count", value: float64(s.GetSampleCount())},
+		}
+		for _, q := range s.GetQuantile() {
+			res = append(res, flatSample{
+				name:  name,
+				extra: &remoteLabel{name: "quantile", value: strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)},
+				value: q.GetValue(),
+			})
+		}
+		return res
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		h := m.GetHistogram()
+		res := []flatSample{
+			{name: name + "_sum", value: h.GetSampleSum()},
+			{name: name + "_count", value: float64(h.GetSampleCount())},
+			{name: name + "_bucket", extra: &remoteLabel{name: "le", value: "+Inf"}, value: float64(h.GetSampleCount())},
+		}
+		for _, b := range h.GetBucket() {
+			if math.IsInf(b.GetUpperBound(), 1) {
+				continue
+			}
+			res = append(res, flatSample{
+				name:  name + "_bucket",
+				extra: &remoteLabel{name: "le", value: strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)},
+				value: float64(b.GetCumulativeCount()),
+			})
+		}
+		return res
+	default:
+		return []flatSample{{name: name, value: m.GetUntyped().GetValue()}}
+	}
+}
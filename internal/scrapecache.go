@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ScrapeCache deduplicates collections triggered by scrapes in active mode.
+// Concurrent callers share one in-flight collection, and a recent enough
+// result is reused instead of starting turbostat again.
+type ScrapeCache struct {
+	collect func()
+	// maxAge is compared to the start of the last collection, as that is when
+	// its measurement interval began.
+	maxAge time.Duration
+	// minSpacing is compared to the end of the last collection and prevents
+	// back to back runs when collections take longer than maxAge.
+	minSpacing time.Duration
+	now        func() time.Time
+
+	mu           sync.Mutex
+	inflight     chan struct{}
+	lastStart    time.Time
+	lastFinished time.Time
+}
+
+func NewScrapeCache(collect func(), maxAge, minSpacing time.Duration) *ScrapeCache {
+	return &ScrapeCache{
+		collect:    collect,
+		maxAge:     maxAge,
+		minSpacing: minSpacing,
+		now:        time.Now,
+	}
+}
+
+// Collect makes sure a fresh enough collection exists. It returns once the
+// shared collection finished or ctx is done; the collection itself keeps
+// running for the other waiters.
+func (c *ScrapeCache) Collect(ctx context.Context) {
+	c.mu.Lock()
+	if done := c.inflight; done != nil {
+		c.mu.Unlock()
+		wait(ctx, done)
+		return
+	}
+
+	now := c.now()
+	if !c.lastFinished.IsZero() {
+		if c.maxAge > 0 && now.Sub(c.lastStart) < c.maxAge {
+			c.mu.Unlock()
+			return
+		}
+		if c.minSpacing > 0 && now.Sub(c.lastFinished) < c.minSpacing {
+			c.mu.Unlock()
+			return
+		}
+	}
+
+	done := make(chan struct{})
+	c.inflight = done
+	c.mu.Unlock()
+
+	go func() {
+		c.collect()
+
+		c.mu.Lock()
+		c.lastStart = now
+		c.lastFinished = c.now()
+		c.inflight = nil
+		c.mu.Unlock()
+		close(done)
+	}()
+
+	wait(ctx, done)
+}
+
+func wait(ctx context.Context, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScrapeCache_SharesInflightCollection(t *testing.T) {
+	var runs atomic.Int32
+	release := make(chan struct{})
+	cache := NewScrapeCache(func() {
+		runs.Add(1)
+		<-release
+	}, 0, 0)
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Collect(context.Background())
+		}()
+	}
+
+	// give all callers time to join the first collection
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := runs.Load(); n != 1 {
+		t.Errorf("expected concurrent scrapes to share 1 collection, got %d", n)
+	}
+}
+
+func TestScrapeCache_MaxAgeAndMinSpacing(t *testing.T) {
+	var runs int
+	now := time.Now()
+	cache := NewScrapeCache(func() {
+		runs++
+		// every collection takes 5 seconds
+		now = now.Add(5 * time.Second)
+	}, 3*time.Second, 10*time.Second)
+	cache.now = func() time.Time { return now }
+
+	cache.Collect(context.Background())
+	if runs != 1 {
+		t.Fatalf("expected first scrape to collect, got %d runs", runs)
+	}
+
+	// result started 5s ago, older than max age but within min spacing
+	cache.Collect(context.Background())
+	if runs != 1 {
+		t.Errorf("expected min spacing to skip the collection, got %d runs", runs)
+	}
+
+	now = now.Add(10 * time.Second)
+	cache.Collect(context.Background())
+	if runs != 2 {
+		t.Errorf("expected a new collection after min spacing, got %d runs", runs)
+	}
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// Levels are the row categories of turbostat output, from the summary row
+// down to single CPUs.
+var Levels = []string{"total", "package", "core", "cpu"}
+
+// SeriesFilter selects the series TurbostatExporter.Update creates. Unlike
+// TURBOSTAT_SHOW/HIDE it doesn't change what turbostat collects, so the
+// snapshot and other outputs keep all columns.
+type SeriesFilter struct {
+	// Include and Exclude match whole column names like Busy% or CPU%c6.
+	// Exclude wins if both match, a nil Include matches every column.
+	Include, Exclude *regexp.Regexp
+	// DisabledLevels are categories without any series, e.g. cpu.
+	DisabledLevels []string
+}
+
+// NewSeriesFilter compiles the include and exclude expressions, empty ones
+// are ignored. enabledLevels lists the levels to export.
+func NewSeriesFilter(include, exclude string, enabledLevels []string) (SeriesFilter, error) {
+	var f SeriesFilter
+	var err error
+	if f.Include, err = compileColumnRegexp(include); err != nil {
+		return f, fmt.Errorf("invalid include expression: %w", err)
+	}
+	if f.Exclude, err = compileColumnRegexp(exclude); err != nil {
+		return f, fmt.Errorf("invalid exclude expression: %w", err)
+	}
+	for _, level := range enabledLevels {
+		if !slices.Contains(Levels, level) {
+			return f, fmt.Errorf("unknown level %q, expected one of %v", level, Levels)
+		}
+	}
+	for _, level := range Levels {
+		if !slices.Contains(enabledLevels, level) {
+			f.DisabledLevels = append(f.DisabledLevels, level)
+		}
+	}
+	return f, nil
+}
+
+// compileColumnRegexp anchors expr, like label matchers in PromQL.
+func compileColumnRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// LevelEnabled reports whether series of the category are exported.
+func (f SeriesFilter) LevelEnabled(category string) bool {
+	return !slices.Contains(f.DisabledLevels, category)
+}
+
+// ColumnAllowed reports whether series of the column are exported.
+func (f SeriesFilter) ColumnAllowed(column string) bool {
+	if f.Exclude != nil && f.Exclude.MatchString(column) {
+		return false
+	}
+	return f.Include == nil || f.Include.MatchString(column)
+}
+
+// apply returns a copy of row without the excluded columns and the number of
+// dropped values.
+func (f SeriesFilter) apply(row TurbostatRow) (TurbostatRow, int) {
+	if f.Include == nil && f.Exclude == nil {
+		return row, 0
+	}
+	dropped := 0
+	filter := func(values map[string]float64) map[string]float64 {
+		res := make(map[string]float64, len(values))
+		for column, v := range values {
+			if f.ColumnAllowed(column) {
+				res[column] = v
+			} else {
+				dropped++
+			}
+		}
+		return res
+	}
+	row.Other = filter(row.Other)
+	row.OtherPercent = filter(row.OtherPercent)
+	return row, dropped
+}
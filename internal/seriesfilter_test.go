@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSeriesFilter_ColumnAllowed(t *testing.T) {
+	f, err := NewSeriesFilter(`.*MHz|Busy%|CPU%c\d+|PkgWatt`, `TSC_MHz|CPU%c1`, Levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for column, want := range map[string]bool{
+		"Bzy_MHz": true,
+		"Busy%":   true,
+		"CPU%c6":  true,
+		"TSC_MHz": false,
+		"CPU%c1":  false,
+		"IRQ":     false,
+		// expressions match whole names
+		"PkgWatt2": false,
+	} {
+		if got := f.ColumnAllowed(column); got != want {
+			t.Errorf("%s: expected %v, got %v", column, want, got)
+		}
+	}
+
+	if _, err := NewSeriesFilter("(", "", Levels); err == nil {
+		t.Error("expected an invalid expression to be rejected")
+	}
+	if _, err := NewSeriesFilter("", "", []string{"thread"}); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+}
+
+func TestExporter_SeriesFilter(t *testing.T) {
+	headers := []string{"Package", "Core", "CPU", "Busy%", "Bzy_MHz", "IRQ", "PkgWatt"}
+	rows := [][]string{
+		{"-", "-", "-", "2.00", "800", "100", "20"},
+		{"0", "0", "0", "1.00", "700", "60", "20"},
+		{"0", "1", "1", "3.00", "900", "40"},
+	}
+	// the first CPU of a core carries the core and package columns
+	parsed := NewTurbostatParser().ParseRowsSimple(headers, rows)
+
+	f, err := NewSeriesFilter("", "IRQ", []string{"total", "package", "core"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+	exporter.SetSeriesFilter(prefixed(registry), f)
+	exporter.Update(FlattenRows(parsed))
+
+	if n := testutil.CollectAndCount(exporter.cpus) + testutil.CollectAndCount(exporter.cpusPercent); n != 0 {
+		t.Errorf("expected no per-CPU series, got %d", n)
+	}
+	expected := `
+# HELP turbostat_exporter_suppressed_series Number of series dropped by the filters and series limits in the last collection.
+# TYPE turbostat_exporter_suppressed_series gauge
+turbostat_exporter_suppressed_series{level="core"} 1
+turbostat_exporter_suppressed_series{level="cpu"} 3
+turbostat_exporter_suppressed_series{level="package"} 0
+turbostat_exporter_suppressed_series{level="total"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "turbostat_exporter_suppressed_series"); err != nil {
+		t.Error(err)
+	}
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP turbostat_total Metrics for the whole system. First line in output.
+# TYPE turbostat_total gauge
+turbostat_total{type="bzy_mhz"} 800
+turbostat_total{type="pkgwatt"} 20
+`), "turbostat_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// SeriesLimits bound the series the generic metrics create per collection.
+// 0 disables a limit.
+type SeriesLimits struct {
+	// Total limits the series of all families together.
+	Total int
+	// PerFamily limits every family like turbostat_cpus_percent on its own.
+	PerFamily int
+}
+
+// limitDropOrder are the levels dropped one after another while a limit is
+// exceeded. Levels without counted series are skipped. The summary row is
+// bounded by the number of columns and always kept.
+var limitDropOrder = []string{"cpu", "core", "package"}
+
+// familyName returns the name of the generic metric of a level without the
+// metric prefix.
+func familyName(category string, percent bool) string {
+	name := map[string]string{
+		"total":   "total",
+		"package": "packages",
+		"core":    "cores",
+		"cpu":     "cpus",
+	}[category]
+	if percent {
+		name += "_percent"
+	}
+	return name
+}
+
+// SetSeriesLimits makes Update drop whole levels, cpu first, while the
+// series of a collection exceed limits. turbostat_series_limit_exceeded is 1
+// as long as levels are dropped.
+func (e *TurbostatExporter) SetSeriesLimits(reg prometheus.Registerer, limits SeriesLimits) {
+	e.limits = &limits
+	e.limitExceeded = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "series_limit_exceeded",
+		Help: "1 if the last collection exceeded a series limit and levels were dropped, 0 otherwise.",
+	})
+	reg.MustRegister(e.limitExceeded)
+	e.registerSuppressed(reg)
+}
+
+// limitSeries returns rows without the levels that have to be dropped to
+// stay within the limits and counts the dropped values in suppressed.
+func (e *TurbostatExporter) limitSeries(rows []TurbostatRow, suppressed map[string]int) []TurbostatRow {
+	counts := map[string]int{}
+	for i := range rows {
+		row := &rows[i]
+		if row.Category == "cpu" && e.cpuHistograms != nil {
+			// replaced by a few histograms per package
+			continue
+		}
+		counts[familyName(row.Category, false)] += len(row.Other)
+		counts[familyName(row.Category, true)] += len(row.OtherPercent)
+	}
+
+	var dropped []string
+	for _, level := range limitDropOrder {
+		if !e.limits.exceeded(counts) {
+			break
+		}
+		// e.g. cpu rows replaced by histograms don't add to the problem
+		if counts[familyName(level, false)]+counts[familyName(level, true)] == 0 {
+			continue
+		}
+		dropped = append(dropped, level)
+		delete(counts, familyName(level, false))
+		delete(counts, familyName(level, true))
+	}
+
+	exceeded := len(dropped) > 0
+	if exceeded {
+		e.limitExceeded.Set(1)
+	} else {
+		e.limitExceeded.Set(0)
+	}
+	// only log changes, the same host exceeds the limits every collection
+	if key := strings.Join(dropped, ","); key != e.limitDropped {
+		e.limitDropped = key
+		if exceeded {
+			log.Warn().Msgf("Series limits (total %d, per family %d) exceeded, dropping the levels %s", e.limits.Total, e.limits.PerFamily, key)
+		} else {
+			log.Info().Msg("Series are within the limits again, exporting all levels")
+		}
+	}
+	if !exceeded {
+		return rows
+	}
+
+	kept := rows[:0:0]
+	for _, row := range rows {
+		if slices.Contains(dropped, row.Category) {
+			suppressed[row.Category] += len(row.Other) + len(row.OtherPercent)
+			continue
+		}
+		kept = append(kept, row)
+	}
+	return kept
+}
+
+func (l *SeriesLimits) exceeded(counts map[string]int) bool {
+	total := 0
+	for _, n := range counts {
+		if l.PerFamily > 0 && n > l.PerFamily {
+			return true
+		}
+		total += n
+	}
+	return l.Total > 0 && total > l.Total
+}
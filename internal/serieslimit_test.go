@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// limitRows returns a summary, 2 package, 4 core and 8 cpu rows with one
+// value and one percentage each.
+func limitRows() []TurbostatRow {
+	row := func(category, pkg, core, cpu string) TurbostatRow {
+		return TurbostatRow{Category: category, Pkg: pkg, Core: core, CPU: cpu,
+			Other: map[string]float64{"Bzy_MHz": 1000}, OtherPercent: map[string]float64{"Busy%": 10}}
+	}
+	rows := []TurbostatRow{row("total", "", "", "")}
+	for cpu := range 8 {
+		pkg, core := string(rune('0'+cpu/4)), string(rune('0'+cpu/2))
+		if cpu%4 == 0 {
+			rows = append(rows, row("package", pkg, "", ""))
+		}
+		if cpu%2 == 0 {
+			rows = append(rows, row("core", pkg, core, ""))
+		}
+		rows = append(rows, row("cpu", pkg, core, string(rune('0'+cpu))))
+	}
+	return rows
+}
+
+func TestExporter_SeriesLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   SeriesLimits
+		exceeded float64
+		// series of turbostat_cores and turbostat_cpus
+		cores, cpus int
+	}{
+		{"within limits", SeriesLimits{Total: 30, PerFamily: 8}, 0, 4, 8},
+		{"per family", SeriesLimits{PerFamily: 7}, 1, 4, 0},
+		{"total drops cpu", SeriesLimits{Total: 20}, 1, 4, 0},
+		{"total drops core", SeriesLimits{Total: 10}, 1, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+			exporter.SetSeriesLimits(prefixed(registry), tt.limits)
+			exporter.Update(limitRows())
+
+			if got := testutil.ToFloat64(exporter.limitExceeded); got != tt.exceeded {
+				t.Errorf("expected turbostat_series_limit_exceeded %v, got %v", tt.exceeded, got)
+			}
+			if n := testutil.CollectAndCount(exporter.cores); n != tt.cores {
+				t.Errorf("expected %d core series, got %d", tt.cores, n)
+			}
+			if n := testutil.CollectAndCount(exporter.cpus); n != tt.cpus {
+				t.Errorf("expected %d cpu series, got %d", tt.cpus, n)
+			}
+			if n := testutil.CollectAndCount(exporter.packages); n != 2 {
+				t.Errorf("expected the package series to be kept, got %d", n)
+			}
+		})
+	}
+}
+
+func TestExporter_SeriesLimitsRecover(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+	exporter.SetSeriesLimits(prefixed(registry), SeriesLimits{PerFamily: 4})
+	exporter.Update(limitRows())
+
+	expected := `
+# HELP turbostat_exporter_suppressed_series Number of series dropped by the filters and series limits in the last collection.
+# TYPE turbostat_exporter_suppressed_series gauge
+turbostat_exporter_suppressed_series{level="core"} 0
+turbostat_exporter_suppressed_series{level="cpu"} 16
+turbostat_exporter_suppressed_series{level="package"} 0
+turbostat_exporter_suppressed_series{level="total"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "turbostat_exporter_suppressed_series"); err != nil {
+		t.Error(err)
+	}
+
+	// a smaller host fits again
+	exporter.Update(limitRows()[:6])
+	if got := testutil.ToFloat64(exporter.limitExceeded); got != 0 {
+		t.Errorf("expected the limit to be no longer exceeded, got %v", got)
+	}
+	if n := testutil.CollectAndCount(exporter.cpus); n != 2 {
+		t.Errorf("expected the cpu series to be back, got %d", n)
+	}
+}
+
+func TestExporter_SeriesLimitsWithCPUHistograms(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+	exporter.EnableCPUHistograms(prefixed(registry), CPUHistogramOptions{Columns: []string{"Busy%"}, Classic: true})
+	exporter.SetSeriesLimits(prefixed(registry), SeriesLimits{PerFamily: 3})
+	exporter.Update(limitRows())
+
+	// the 4 core series exceed the limit, the cpu rows only feed histograms
+	if n := testutil.CollectAndCount(exporter.cores); n != 0 {
+		t.Errorf("expected the core level to be dropped, got %d series", n)
+	}
+	if h := histogramOf(t, registry, "turbostat_cpus_busy_percent", "0"); h.GetSampleCount() != 4 {
+		t.Errorf("expected the 4 CPUs of package 0 to still be observed, got %d", h.GetSampleCount())
+	}
+	if got := testutil.ToFloat64(exporter.suppressed.WithLabelValues("cpu")); got != 0 {
+		t.Errorf("expected no suppressed cpu series, got %v", got)
+	}
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Snapshot is the parsed result of a single collection.
+type Snapshot struct {
+	Timestamp time.Time
+	Duration  time.Duration
+	Headers   []string
+	Rows      []TurbostatRow
+}
+
+// SnapshotStore holds the latest Snapshot. It is safe for concurrent use.
+type SnapshotStore struct {
+	mu     sync.RWMutex
+	latest *Snapshot
+}
+
+func NewSnapshotStore() *SnapshotStore {
+	return &SnapshotStore{}
+}
+
+// Set replaces the latest snapshot. The snapshot must not be modified afterwards.
+func (s *SnapshotStore) Set(snap *Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest = snap
+}
+
+// Latest returns the latest snapshot or nil if there was no collection yet.
+func (s *SnapshotStore) Latest() *Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latest
+}
+
+// SnapshotFilter selects rows of a snapshot. Empty fields match everything.
+type SnapshotFilter struct {
+	Categories []string
+	Packages   []string
+	CPUs       map[int]bool
+}
+
+// ParseSnapshotFilter reads the category, package and cpu query parameters.
+// Each of them accepts a comma separated list, cpu additionally accepts
+// ranges like "0-3,8".
+func ParseSnapshotFilter(query map[string][]string) (SnapshotFilter, error) {
+	var filter SnapshotFilter
+
+	for _, v := range query["category"] {
+		for c := range strings.SplitSeq(v, ",") {
+			switch c {
+			case "total", "package", "core", "cpu":
+				filter.Categories = append(filter.Categories, c)
+			default:
+				return filter, fmt.Errorf("unknown category %q", c)
+			}
+		}
+	}
+
+	for _, v := range query["package"] {
+		filter.Packages = append(filter.Packages, strings.Split(v, ",")...)
+	}
+
+	for _, v := range query["cpu"] {
+		cpus, err := ParseCPUList(v)
+		if err != nil {
+			return filter, err
+		}
+		if filter.CPUs == nil {
+			filter.CPUs = map[int]bool{}
+		}
+		for _, c := range cpus {
+			filter.CPUs[c] = true
+		}
+	}
+
+	return filter, nil
+}
+
+// Match reports whether row passes the filter. The CPU filter only applies to
+// cpu and core rows, so package and total rows are kept.
+func (f SnapshotFilter) Match(row *TurbostatRow) bool {
+	if len(f.Categories) > 0 && !slices.Contains(f.Categories, row.Category) {
+		return false
+	}
+	if len(f.Packages) > 0 && row.Category != "total" && !slices.Contains(f.Packages, row.Pkg) {
+		return false
+	}
+	if len(f.CPUs) > 0 && (row.Category == "cpu" || row.Category == "core") {
+		cpu, err := strconv.Atoi(row.CPU)
+		if err != nil || !f.CPUs[cpu] {
+			return false
+		}
+	}
+	return true
+}
+
+// maxCPUNumber bounds CPU lists so a range like "0-999999999" can't be used
+// to allocate huge slices.
+const maxCPUNumber = 1 << 16
+
+// ParseCPUList parses a turbostat style CPU list like "0,2,4-7".
+func ParseCPUList(list string) ([]int, error) {
+	var cpus []int
+	for part := range strings.SplitSeq(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(from)
+		if err != nil || start < 0 || start > maxCPUNumber {
+			return nil, fmt.Errorf("invalid cpu %q", part)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(to)
+			if err != nil || end < start || end > maxCPUNumber {
+				return nil, fmt.Errorf("invalid cpu range %q", part)
+			}
+		}
+		for c := start; c <= end; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
+
+type snapshotResponse struct {
+	Timestamp       time.Time         `json:"timestamp"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Units           map[string]string `json:"units"`
+	Rows            []snapshotRow     `json:"rows"`
+}
+
+type snapshotRow struct {
+	Category string             `json:"category"`
+	Package  string             `json:"package,omitempty"`
+	Core     string             `json:"core,omitempty"`
+	CPU      string             `json:"cpu,omitempty"`
+	Values   map[string]float64 `json:"values"`
+}
+
+func newSnapshotResponse(snap *Snapshot, filter SnapshotFilter) snapshotResponse {
+	resp := snapshotResponse{
+		Timestamp:       snap.Timestamp,
+		DurationSeconds: snap.Duration.Seconds(),
+		Units:           map[string]string{},
+		Rows:            []snapshotRow{},
+	}
+
+	for i := range snap.Rows {
+		row := &snap.Rows[i]
+		if !filter.Match(row) {
+			continue
+		}
+
+		values := make(map[string]float64, len(row.Other)+len(row.OtherPercent))
+		maps.Copy(values, row.Other)
+		maps.Copy(values, row.OtherPercent)
+		for column := range values {
+			if _, ok := resp.Units[column]; !ok {
+				resp.Units[column] = LookupColumn(column).Unit
+			}
+		}
+
+		out := snapshotRow{Category: row.Category, Values: values}
+		if row.Category != "total" {
+			out.Package = row.Pkg
+			out.Core = row.Core
+			out.CPU = row.CPU
+		}
+		resp.Rows = append(resp.Rows, out)
+	}
+	return resp
+}
+
+// SnapshotHandler serves the latest snapshot as JSON.
+func SnapshotHandler(store *SnapshotStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter, err := ParseSnapshotFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		snap := store.Latest()
+		if snap == nil {
+			http.Error(w, "no collection finished yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(newSnapshotResponse(snap, filter)); err != nil {
+			log.Error().Err(err).Msg("Failed to write snapshot")
+		}
+	})
+}
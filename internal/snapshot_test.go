@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := ParseCPUList("0,2,4-6")
+	if err != nil {
+		t.Fatalf("expected cpu list to parse, got error: %v", err)
+	}
+	if want := []int{0, 2, 4, 5, 6}; !slices.Equal(cpus, want) {
+		t.Errorf("expected %v, got %v", want, cpus)
+	}
+
+	for _, invalid := range []string{"a", "3-1", "-1", "0-999999999"} {
+		if _, err := ParseCPUList(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestSnapshotHandler_Filter(t *testing.T) {
+	content, err := os.ReadFile("../data/sandy-bridge.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers, rows, err := ParseTurbostatOutput(string(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var allRows []TurbostatRow
+	for _, v := range NewTurbostatParser().ParseRowsSimple(headers, rows) {
+		for _, r := range v {
+			allRows = append(allRows, *r)
+		}
+	}
+
+	store := NewSnapshotStore()
+	h := SnapshotHandler(store)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/snapshot", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without snapshot, got %d", rec.Code)
+	}
+
+	store.Set(&Snapshot{Timestamp: time.Now(), Duration: 5 * time.Second, Headers: headers, Rows: allRows})
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/snapshot?category=cpu&package=1&cpu=0-15", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp snapshotResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("expected valid json, got error: %v", err)
+	}
+	if resp.DurationSeconds != 5 {
+		t.Errorf("expected duration of 5s, got %v", resp.DurationSeconds)
+	}
+	// package 1 holds the odd numbered cpus
+	if len(resp.Rows) != 8 {
+		t.Errorf("expected 8 rows, got %d", len(resp.Rows))
+	}
+	for _, row := range resp.Rows {
+		if row.Category != "cpu" || row.Package != "1" {
+			t.Errorf("unexpected row %+v", row)
+		}
+	}
+	if resp.Units["Busy%"] != UnitPercent || resp.Units["Bzy_MHz"] != UnitMHz {
+		t.Errorf("expected units from the column catalog, got %v", resp.Units)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/snapshot?category=socket", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown category, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CollectionStatus keeps track of the turbostat collection runs. It is safe
+// for concurrent use.
+type CollectionStatus struct {
+	mu           sync.RWMutex
+	lastAttempt  time.Time
+	lastSuccess  time.Time
+	lastDuration time.Duration
+	lastErr      error
+	successes    int
+	failures     int
+	now          func() time.Time
+}
+
+// CollectionStatusSnapshot is a point in time copy of a CollectionStatus.
+type CollectionStatusSnapshot struct {
+	LastAttempt  time.Time
+	LastSuccess  time.Time
+	LastDuration time.Duration
+	LastError    error
+	Successes    int
+	Failures     int
+}
+
+func NewCollectionStatus() *CollectionStatus {
+	return &CollectionStatus{now: time.Now}
+}
+
+// Record stores the outcome of a collection that started at start.
+func (s *CollectionStatus) Record(start time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastAttempt = start
+	s.lastDuration = s.now().Sub(start)
+	s.lastErr = err
+	if err != nil {
+		s.failures++
+		return
+	}
+	s.lastSuccess = start
+	s.successes++
+}
+
+func (s *CollectionStatus) Snapshot() CollectionStatusSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return CollectionStatusSnapshot{
+		LastAttempt:  s.lastAttempt,
+		LastSuccess:  s.lastSuccess,
+		LastDuration: s.lastDuration,
+		LastError:    s.lastErr,
+		Successes:    s.successes,
+		Failures:     s.failures,
+	}
+}
+
+// Ready reports whether at least one collection succeeded and, if maxAge is
+// positive, whether the last successful one is younger than maxAge. The
+// returned string explains the decision.
+func (s *CollectionStatus) Ready(maxAge time.Duration) (bool, string) {
+	snap := s.Snapshot()
+	if snap.Successes == 0 {
+		if snap.LastError != nil {
+			return false, fmt.Sprintf("no successful collection yet, last error: %v", snap.LastError)
+		}
+		return false, "no successful collection yet"
+	}
+
+	age := s.now().Sub(snap.LastSuccess)
+	if maxAge > 0 && age > maxAge {
+		return false, fmt.Sprintf("last successful collection is %s old (max %s)", age.Round(time.Second), maxAge)
+	}
+	return true, fmt.Sprintf("last successful collection %s ago", age.Round(time.Second))
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCollectionStatus_Ready(t *testing.T) {
+	status := NewCollectionStatus()
+	now := time.Now()
+	status.now = func() time.Time { return now }
+
+	if ready, _ := status.Ready(time.Minute); ready {
+		t.Errorf("expected not ready before the first collection")
+	}
+
+	status.Record(now, errors.New("turbostat missing"))
+	if ready, reason := status.Ready(time.Minute); ready {
+		t.Errorf("expected not ready after a failed collection, got %q", reason)
+	}
+
+	status.Record(now, nil)
+	if ready, reason := status.Ready(time.Minute); !ready {
+		t.Errorf("expected ready after a successful collection, got %q", reason)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if ready, _ := status.Ready(time.Minute); ready {
+		t.Errorf("expected not ready when the last success is too old")
+	}
+	if ready, _ := status.Ready(0); !ready {
+		t.Errorf("expected ready when max age is disabled")
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	status := NewCollectionStatus()
+	h := ReadyzHandler(status, 0)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before the first collection, got %d", rec.Code)
+	}
+
+	status.Record(time.Now(), nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 after a collection, got %d", rec.Code)
+	}
+}
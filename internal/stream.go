@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReadBlocks incrementally splits the output of a long running
+// `turbostat --interval N` into one block per interval and calls emit with the
+// raw text of every block, ready for ParseTurbostatOutput.
+//
+// A block ends at the next header line, at an empty line, or when no new line
+// arrived for flushAfter, so the last interval isn't held back until the next
+// one starts. Rows arriving after a flush get the last seen header prepended.
+// ReadBlocks returns nil on EOF.
+func ReadBlocks(ctx context.Context, r io.Reader, flushAfter time.Duration, emit func(block string)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	var (
+		header string
+		block  []string
+		rows   int
+	)
+	flush := func() {
+		if rows > 0 {
+			emit(strings.Join(block, "\n") + "\n")
+		} else if len(block) > 0 {
+			log.Debug().Msgf("Dropping %d lines without turbostat rows", len(block))
+		}
+		block = block[:0]
+		rows = 0
+	}
+
+	timer := time.NewTimer(flushAfter)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case line := <-lines:
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+				flush()
+				continue
+			case isTurbostatHeaderLine(fields):
+				flush()
+				header = line
+				block = append(block, line)
+				continue
+			case header != "" && len(block) == 0:
+				block = append(block, header)
+			}
+
+			block = append(block, line)
+			if header != "" {
+				rows++
+			}
+			timer.Reset(flushAfter)
+		case <-timer.C:
+			flush()
+		case err := <-readErr:
+			flush()
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// StreamSource feeds turbostat interval blocks from a reader that is not
+// started by the exporter, like stdin or a named pipe written by a privileged
+// sidecar.
+type StreamSource struct {
+	name string
+	open func() (io.ReadCloser, error)
+	// reopen makes Run open the input again after EOF, which happens every
+	// time the writer of a FIFO goes away.
+	reopen bool
+	// restartDelay is waited before reopening, so a command failing right
+	// away isn't restarted in a busy loop
+	restartDelay time.Duration
+	flushAfter   time.Duration
+	retryDelay   time.Duration
+}
+
+// NewReaderSource reads from r until EOF, e.g. os.Stdin.
+func NewReaderSource(name string, r io.Reader) *StreamSource {
+	return &StreamSource{
+		name:       name,
+		open:       func() (io.ReadCloser, error) { return io.NopCloser(r), nil },
+		flushAfter: time.Second,
+		retryDelay: time.Second,
+	}
+}
+
+// NewFIFOSource reads from the named pipe at path and opens it again whenever
+// the writer closes it.
+func NewFIFOSource(path string) *StreamSource {
+	return &StreamSource{
+		name:       path,
+		open:       func() (io.ReadCloser, error) { return os.Open(path) },
+		reopen:     true,
+		flushAfter: time.Second,
+		retryDelay: time.Second,
+	}
+}
+
+// NewCommandSource runs binary with args, e.g. turbostat --interval 1, and
+// starts it again whenever it exits. turbostat prints its table to stderr, so
+// stdout and stderr are read together, other lines are skipped by ReadBlocks.
+func NewCommandSource(binary string, args []string) *StreamSource {
+	return &StreamSource{
+		name:         binary,
+		open:         func() (io.ReadCloser, error) { return startCommand(binary, args) },
+		reopen:       true,
+		restartDelay: 5 * time.Second,
+		flushAfter:   time.Second,
+		retryDelay:   5 * time.Second,
+	}
+}
+
+// commandOutput is the combined output of a running command. Closing it
+// stops the command.
+type commandOutput struct {
+	*os.File
+	cmd  *exec.Cmd
+	once sync.Once
+}
+
+func startCommand(binary string, args []string) (io.ReadCloser, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	log.Debug().Msgf("Starting %s %s", binary, strings.Join(args, " "))
+	err = cmd.Start()
+	// the command has its own copy, reading ends once it exits
+	_ = w.Close()
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return &commandOutput{File: r, cmd: cmd}, nil
+}
+
+func (c *commandOutput) Close() error {
+	c.once.Do(func() {
+		_ = c.cmd.Process.Kill()
+		_ = c.cmd.Wait()
+		_ = c.File.Close()
+	})
+	return nil
+}
+
+func (s *StreamSource) Name() string {
+	return s.name
+}
+
+// Run passes every block to handle until ctx is done or, for sources which
+// are not reopened, the input ends.
+func (s *StreamSource) Run(ctx context.Context, handle func(block string)) error {
+	for {
+		r, err := s.openInput(ctx)
+		if err != nil {
+			return err
+		}
+
+		// closing the input unblocks a pending read when ctx is cancelled
+		stop := context.AfterFunc(ctx, func() { r.Close() })
+		err = ReadBlocks(ctx, r, s.flushAfter, handle)
+		stop()
+		r.Close()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			log.Warn().Err(err).Msgf("Failed to read turbostat output from %s", s.name)
+		}
+		if !s.reopen {
+			log.Info().Msgf("Reached end of turbostat output from %s", s.name)
+			return err
+		}
+		if s.restartDelay == 0 {
+			log.Info().Msgf("Writer of %s went away, reopening", s.name)
+			continue
+		}
+		log.Warn().Msgf("%s exited, restarting in %s", s.name, s.restartDelay)
+		select {
+		case <-time.After(s.restartDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *StreamSource) openInput(ctx context.Context) (io.ReadCloser, error) {
+	for {
+		r, err := s.open()
+		if err == nil {
+			return r, nil
+		}
+		log.Warn().Err(err).Msgf("Failed to open %s, retrying in %s", s.name, s.retryDelay)
+
+		select {
+		case <-time.After(s.retryDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadBlocks_SplitsIntervals(t *testing.T) {
+	input := `turbostat: some warning
+Core	CPU	Avg_MHz	Busy%
+-	-	125	6.57
+0	0	80	9.91
+Core	CPU	Avg_MHz	Busy%
+-	-	130	7.00
+0	0	90	10.00
+
+-	-	140	8.00
+`
+	var blocks []string
+	err := ReadBlocks(context.Background(), strings.NewReader(input), time.Minute, func(block string) {
+		blocks = append(blocks, block)
+	})
+	if err != nil {
+		t.Fatalf("expected EOF to end reading without error, got %v", err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %d: %q", len(blocks), blocks)
+	}
+
+	for i, block := range blocks {
+		headers, rows, err := ParseTurbostatOutput(block)
+		if err != nil {
+			t.Fatalf("block %d: expected parsing to succeed, got error: %v", i, err)
+		}
+		if len(headers) != 4 {
+			t.Errorf("block %d: expected 4 headers, got %d", i, len(headers))
+		}
+		if i < 2 && len(rows) != 2 {
+			t.Errorf("block %d: expected 2 rows, got %d", i, len(rows))
+		}
+	}
+	// the last block reuses the header of the previous interval
+	if !strings.HasPrefix(blocks[2], "Core") {
+		t.Errorf("expected last header to be prepended, got %q", blocks[2])
+	}
+}
+
+func TestReadBlocks_FlushesAfterIdle(t *testing.T) {
+	r, w := io.Pipe()
+	blocks := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- ReadBlocks(context.Background(), r, 20*time.Millisecond, func(block string) { blocks <- block })
+	}()
+
+	if _, err := io.WriteString(w, "Core\tCPU\tBusy%\n-\t-\t6.57\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case block := <-blocks:
+		if !strings.Contains(block, "6.57") {
+			t.Errorf("unexpected block %q", block)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected block to be emitted while the writer is still open")
+	}
+
+	w.Close()
+	if err := <-done; err != nil {
+		t.Errorf("expected nil error at EOF, got %v", err)
+	}
+}
+
+func TestCommandSource_RestartsCommand(t *testing.T) {
+	source := NewCommandSource("sh", []string{"-c", "printf 'CPU\tBusy%%\n-\t1.00\n'"})
+	source.restartDelay = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blocks := 0
+	err := source.Run(ctx, func(block string) {
+		blocks++
+		// the command exits after one block, a second one proves the restart
+		if blocks == 2 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("expected the source to run until canceled, got %v", err)
+	}
+	if blocks != 2 {
+		t.Errorf("expected 2 blocks, got %d", blocks)
+	}
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// TextfileWriter writes metrics to a file read by the textfile collector of
+// node_exporter.
+type TextfileWriter struct {
+	path     string
+	mode     os.FileMode
+	gatherer prometheus.Gatherer
+}
+
+// NewTextfileWriter writes the metrics of gatherer to path with the given file
+// mode. The gatherer should leave out the go_* and process_* metrics of the
+// default registry, node_exporter exposes its own, see TurbostatGatherer.
+func NewTextfileWriter(path string, mode os.FileMode, gatherer prometheus.Gatherer) (*TextfileWriter, error) {
+	if !strings.HasSuffix(path, ".prom") {
+		return nil, fmt.Errorf("textfile %s must end in .prom to be read by node_exporter", path)
+	}
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", filepath.Dir(path))
+	}
+	return &TextfileWriter{path: path, mode: mode, gatherer: gatherer}, nil
+}
+
+func (t *TextfileWriter) Path() string {
+	return t.path
+}
+
+// Write replaces the file atomically: the metrics are written to a temporary
+// file in the same directory which is renamed over the target, so
+// node_exporter never reads a partially written file.
+func (t *TextfileWriter) Write() error {
+	families, err := t.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	// node_exporter ignores files not ending in .prom, so the temporary file
+	// is never picked up
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), "."+filepath.Base(t.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// no-op after a successful rename
+		_ = os.Remove(tmp.Name())
+	}()
+
+	enc := expfmt.NewEncoder(tmp, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := tmp.Chmod(t.mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
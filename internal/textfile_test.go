@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+func TestTextfileWriter_WritesOnlyTurbostatMetrics(t *testing.T) {
+	dir := t.TempDir()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector())
+	exporter := NewTurbostatExporterWithRegisterer(prefixed(registry))
+	exporter.Update([]TurbostatRow{{Category: "total", Other: map[string]float64{"PkgWatt": 12.5}, OtherPercent: map[string]float64{}}})
+
+	if _, err := NewTextfileWriter(filepath.Join(dir, "turbostat.txt"), 0o644, registry); err == nil {
+		t.Error("expected a file name without .prom to be rejected")
+	}
+
+	writer, err := NewTextfileWriter(filepath.Join(dir, "turbostat.prom"), 0o640, TurbostatGatherer(registry, DefaultMetricPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(); err != nil {
+		t.Fatalf("expected write to succeed, got error: %v", err)
+	}
+
+	content, err := os.ReadFile(writer.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `turbostat_total{type="pkgwatt"} 12.5`) {
+		t.Errorf("expected turbostat metrics in textfile, got:\n%s", content)
+	}
+	if strings.Contains(string(content), "go_") {
+		t.Error("expected go runtime metrics to be left out")
+	}
+
+	info, err := os.Stat(writer.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("expected mode 0640, got %#o", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left, got %d entries", len(entries))
+	}
+}
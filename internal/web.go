@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LandingSetting is a single name/value pair shown in the configuration
+// summary of the landing page.
+type LandingSetting struct {
+	Name  string
+	Value string
+}
+
+// LandingLink points to an endpoint served by the exporter.
+type LandingLink struct {
+	Path        string
+	Description string
+}
+
+type LandingPageConfig struct {
+	Version  string
+	Settings []LandingSetting
+	Links    []LandingLink
+}
+
+var landingPageTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Turbostat Exporter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { text-align: left; padding: 0.2em 1em 0.2em 0; }
+.ok { color: #2e7d32; }
+.error { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>Turbostat Exporter</h1>
+<p>Version: {{.Version}}</p>
+<h2>Endpoints</h2>
+<ul>
+{{range .Links}}<li><a href="{{.Path}}">{{.Path}}</a> - {{.Description}}</li>
+{{end}}</ul>
+<h2>Last collection</h2>
+<table>
+<tr><th>Status</th><td>{{if .Ready}}<span class="ok">{{.ReadyReason}}</span>{{else}}<span class="error">{{.ReadyReason}}</span>{{end}}</td></tr>
+<tr><th>Last attempt</th><td>{{.LastAttempt}}</td></tr>
+<tr><th>Last success</th><td>{{.LastSuccess}}</td></tr>
+<tr><th>Duration</th><td>{{.Status.LastDuration}}</td></tr>
+<tr><th>Last error</th><td>{{if .Status.LastError}}<span class="error">{{.Status.LastError}}</span>{{else}}-{{end}}</td></tr>
+<tr><th>Successful / failed</th><td>{{.Status.Successes}} / {{.Status.Failures}}</td></tr>
+</table>
+<h2>Configuration</h2>
+<table>
+{{range .Settings}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// NewLandingPageHandler renders an overview with version, configuration and
+// the state of the last collection.
+func NewLandingPageHandler(cfg LandingPageConfig, status *CollectionStatus, readyMaxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		ready, reason := status.Ready(readyMaxAge)
+		snap := status.Snapshot()
+		data := struct {
+			LandingPageConfig
+			Status      CollectionStatusSnapshot
+			Ready       bool
+			ReadyReason string
+			LastAttempt string
+			LastSuccess string
+		}{
+			LandingPageConfig: cfg,
+			Status:            snap,
+			Ready:             ready,
+			ReadyReason:       reason,
+			LastAttempt:       formatTime(snap.LastAttempt),
+			LastSuccess:       formatTime(snap.LastSuccess),
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingPageTemplate.Execute(w, data); err != nil {
+			log.Error().Err(err).Msg("Failed to render landing page")
+		}
+	})
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// HealthzHandler reports that the process is alive and serving requests.
+func HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// ReadyzHandler answers 200 once a collection succeeded and the last
+// successful one is younger than maxAge, otherwise 503.
+func ReadyzHandler(status *CollectionStatus, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		ready, reason := status.Ready(maxAge)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, reason)
+	})
+}
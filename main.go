@@ -4,15 +4,27 @@ import (
 	"blackdark/turbostat-exporter/internal"
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -27,11 +39,77 @@ var (
 	basicAuthUsername         string
 	basicAuthPassword         string
 	basicAuthEnabled          = false
+	bearerTokens              []string
+	bearerTokenFile           string
+	htpasswdFile              string
+	authExemptPaths           = []string{"/healthz", "/readyz"}
+	authMaxFailures           = 10
+	authFailureWindow         = 60 * time.Second
 	listenAddr                = "0.0.0.0:9101"
+	readyMaxAge               time.Duration
+	collectionStatus          = internal.NewCollectionStatus()
+	snapshotStore             = internal.NewSnapshotStore()
+	debugStore                = internal.NewDebugStore()
+	debugEndpointEnabled      = false
+	activeMaxAge              time.Duration
+	activeMinSpacing          time.Duration
+	turbostatInvocation       = internal.NewTurbostatInvocation()
+	customCounters            []internal.CustomCounter
+	inputFIFO                 string
+	readFromStdin             = false
+	oneShot                   = false
+	httpEnabled               = true
+	textfilePath              string
+	textfileMode              os.FileMode = 0o644
+	afterCollection           []func()
+	onShutdown                []func(ctx context.Context)
+	shutdownTimeout           = 10 * time.Second
+	pushgatewayURL            string
+	pushgatewayAuth           internal.PushAuth
+	pushgatewayDelete         = true
+	remoteWriteURL            string
+	remoteWriteAuth           internal.PushAuth
+	pushJob                   = "turbostat"
+	pushInstance              string
+	pushOptions               = internal.PushOptions{BufferSize: 100, MaxRetries: 3, RetryBackoff: time.Second}
+	pushTimeout               = 10 * time.Second
+	otlpConfig                = internal.OTLPConfig{Protocol: "http/protobuf", Timeout: 10 * time.Second}
+	mqttConfig                = internal.MQTTConfig{Retain: true, Timeout: 10 * time.Second}
+	influxConfig              internal.InfluxConfig
+	historyEnabled            = false
+	historyRetention          = time.Hour
+	historyResolution         = 10 * time.Second
+	historyMaxSeries          = 20000
+	historyStore              *internal.HistoryStore
+	dashboardEnabled          = false
+	dashboardMaxClients       = 10
+	broadcaster               *internal.SnapshotBroadcaster
+	streamSource              *internal.StreamSource
+	sampleInterval            time.Duration
+	aggregateOptions          = internal.AggregateOptions{MaxSamples: 3600}
+	aggregator                *internal.Aggregator
+	cpuHistogramsEnabled      = false
+	seriesFilter              *internal.SeriesFilter
+	seriesLimits              internal.SeriesLimits
+	metricPrefix              = internal.DefaultMetricPrefix
+	constantLabels            prometheus.Labels
+	defaultModules                  = internal.Modules
+	cpuHistogramOptions             = internal.CPUHistogramOptions{Columns: internal.DefaultCPUHistogramColumns, Native: true, Classic: true}
+	probeEnabled                    = false
+	probeLimits                     = internal.ProbeLimits{MinSeconds: 1, MaxSeconds: 30, MaxConcurrent: 1}
+	ingestEnabled                   = false
+	ingestStaleness                 = 300 * time.Second
+	ingestMaxBytes            int64 = 1 << 20
+	ingestMaxInstances              = 100
 )
 
 func main() {
 	flagVersion := flag.Bool("version", false, "prints the version")
+	flagStdin := flag.Bool("stdin", false, "read turbostat output from stdin instead of running turbostat")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s once [--seconds N] [--format prom|json|csv]\n       %s inspect <capture.tsv|->\n\nFlags:\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 	if *flagVersion {
 		fmt.Println(Version)
@@ -41,62 +119,288 @@ func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
-	parser := internal.NewTurbostatParser()
-	exporter := internal.NewTurbostatExporter()
+	readFromStdin = *flagStdin
+
+	switch flag.Arg(0) {
+	case "once":
+		oneShot = true
+		parseConfiguration()
+		os.Exit(runOnce(flag.Args()[1:]))
+	case "inspect":
+		oneShot = true
+		parseConfiguration()
+		os.Exit(runInspect(flag.Args()[1:]))
+	}
+
+	fmt.Println("Prometheus turbostat exporter - created by BlackDark (https://github.com/BlackDark/prometheus_turbostat_exporter)")
+	parseConfiguration()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(constantLabels) > 0 {
+		labelDefaultCollectors()
+	}
+
+	parser := newParser()
+	exporter := newExporter(prometheus.DefaultRegisterer)
+
+	if readFromStdin {
+		streamSource = internal.NewReaderSource("stdin", os.Stdin)
+	} else if inputFIFO != "" {
+		streamSource = internal.NewFIFOSource(inputFIFO)
+	} else if sampleInterval > 0 {
+		streamSource = internal.NewCommandSource(turbostatInvocation.Binary, turbostatInvocation.IntervalArgv(sampleInterval))
+	}
+
+	if len(aggregateOptions.Columns) > 0 {
+		aggregator = internal.NewAggregator(aggregateOptions)
+		metricsRegisterer().MustRegister(aggregator)
+		afterCollection = append(afterCollection, func() { aggregator.Add(snapshotStore.Latest()) })
+	}
+
+	if historyEnabled {
+		historyStore = internal.NewHistoryStore(historyRetention, historyResolution, historyMaxSeries)
+		afterCollection = append(afterCollection, func() { historyStore.Add(snapshotStore.Latest()) })
+		log.Info().Msgf("Keeping %s of history at %s resolution, using up to %d MiB", historyStore.Retention(), historyStore.Resolution(), historyStore.MaxBytes()>>20)
+	}
+
+	if dashboardEnabled && httpEnabled {
+		broadcaster = internal.NewSnapshotBroadcaster(dashboardMaxClients)
+		afterCollection = append(afterCollection, func() { broadcaster.Publish(snapshotStore.Latest()) })
+	}
+
+	setupOutputs(ctx)
+
+	if streamSource != nil {
+		if sampleInterval > 0 {
+			log.Info().Msgf("Sampling turbostat every %s", sampleInterval)
+		} else {
+			log.Info().Msgf("Reading turbostat output from %s instead of running turbostat", streamSource.Name())
+		}
+		streamDone := make(chan struct{})
+		go func() {
+			defer close(streamDone)
+			runStream(ctx, streamSource, parser, exporter)
+			if !httpEnabled {
+				// nothing left to do once stdin ended
+				stop()
+			}
+		}()
+		if sampleInterval > 0 {
+			// the sampling turbostat is stopped when the stream ends, it would
+			// outlive the exporter otherwise
+			onShutdown = append(onShutdown, func(ctx context.Context) {
+				select {
+				case <-streamDone:
+				case <-ctx.Done():
+				}
+			})
+		}
+	}
 
 	updateFunc := createUpdateFunc(parser, exporter)
 
-	startServer(context.TODO(), updateFunc)
+	startCollecting(ctx, updateFunc)
+	if httpEnabled {
+		startServer(ctx, updateFunc)
+	} else {
+		log.Info().Msg("HTTP server disabled")
+		<-ctx.Done()
+	}
+
+	log.Info().Msg("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, f := range onShutdown {
+		f(shutdownCtx)
+	}
+}
+
+// newParser creates a parser that only keeps the columns selected by the
+// turbostat options.
+func newParser() *internal.TurbostatParser {
+	parser := internal.NewTurbostatParser()
+	parser.SetColumnFilter(internal.KeepCustomColumns(customCounters, turbostatInvocation.Options.ColumnAllowed))
+	return parser
+}
+
+// newExporter creates an exporter with all configured custom counters. Its
+// metrics get the configured prefix and constant labels.
+func newExporter(reg prometheus.Registerer) *internal.TurbostatExporter {
+	return configureExporter(internal.WrapRegisterer(reg, metricPrefix, constantLabels))
+}
+
+// newIngestExporter creates the exporter of an ingested instance. The
+// constant labels describe this host, e.g. its node, so the metrics of other
+// hosts only get the prefix.
+func newIngestExporter(reg prometheus.Registerer) *internal.TurbostatExporter {
+	return configureExporter(internal.WrapRegisterer(reg, metricPrefix, nil))
+}
+
+// configureExporter creates an exporter registered with reg as is and applies
+// the custom counters, histograms, filters and limits.
+func configureExporter(reg prometheus.Registerer) *internal.TurbostatExporter {
+	exporter := internal.NewTurbostatExporterWithRegisterer(reg)
+	exporter.AddCustomCounters(reg, customCounters)
+	if cpuHistogramsEnabled {
+		exporter.EnableCPUHistograms(reg, cpuHistogramOptions)
+	}
+	if seriesFilter != nil {
+		exporter.SetSeriesFilter(reg, *seriesFilter)
+	}
+	if seriesLimits.Total > 0 || seriesLimits.PerFamily > 0 {
+		exporter.SetSeriesLimits(reg, seriesLimits)
+	}
+	return exporter
+}
+
+// metricsRegisterer registers metrics of the exporter with the default
+// registry, adding the configured prefix and constant labels.
+func metricsRegisterer() prometheus.Registerer {
+	return internal.WrapRegisterer(prometheus.DefaultRegisterer, metricPrefix, constantLabels)
+}
+
+// labelDefaultCollectors replaces the default registry with one whose go_*
+// and process_* metrics carry the constant labels. It must run before any
+// metric is registered.
+func labelDefaultCollectors() {
+	registry := prometheus.NewRegistry()
+	prometheus.WrapRegistererWith(constantLabels, registry).MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	prometheus.DefaultRegisterer = registry
+	prometheus.DefaultGatherer = registry
+}
+
+// runStream processes every interval block of a stream source. The duration
+// of a collection is the time since the previous block.
+func runStream(ctx context.Context, source *internal.StreamSource, parser *internal.TurbostatParser, exporter *internal.TurbostatExporter) {
+	last := time.Now()
+	err := source.Run(ctx, func(block string) {
+		start := last
+		last = time.Now()
+		output := programOutput{command: source.Name(), stdout: block, combined: block}
+		err := processOutput(parser, exporter, start, output, nil)
+		collectionStatus.Record(start, err)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to process turbostat output")
+			return
+		}
+		runAfterCollection()
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Error().Err(err).Msgf("Stopped reading from %s", source.Name())
+	}
 }
 
 func createUpdateFunc(parser *internal.TurbostatParser, exporter *internal.TurbostatExporter) func(time.Duration) {
+	// the parser caches the column layout and the exporter resets all gauges on
+	// update, so collections must never run concurrently
+	var mu sync.Mutex
+
 	return func(sleepDuration time.Duration) {
-		content, err := executeProgram(int(sleepDuration / time.Second))
+		mu.Lock()
+		defer mu.Unlock()
+
+		start := time.Now()
+		err := collect(parser, exporter, sleepDuration)
+		collectionStatus.Record(start, err)
 		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to run turbostat")
+			log.Error().Err(err).Msg("Collection failed")
+			return
 		}
+		runAfterCollection()
+	}
+}
 
-		headers, rows, err := internal.ParseTurbostatOutput(content)
+// runAfterCollection passes the metrics of a successful collection on to the
+// configured outputs besides /metrics.
+func runAfterCollection() {
+	for _, f := range afterCollection {
+		f()
+	}
+}
+
+func collect(parser *internal.TurbostatParser, exporter *internal.TurbostatExporter, sleepDuration time.Duration) error {
+	start := time.Now()
+	output, err := executeProgram(context.Background(), int(sleepDuration/time.Second), internal.TurbostatOptions{})
+	return processOutput(parser, exporter, start, output, err)
+}
+
+// processOutput parses turbostat output obtained at start and updates the
+// exporter and all stores. runErr is the error of obtaining the output.
+func processOutput(parser *internal.TurbostatParser, exporter *internal.TurbostatExporter, start time.Time, output programOutput, runErr error) (err error) {
+	capture := &internal.DebugCapture{
+		Timestamp: start,
+		Command:   output.command,
+		Stdout:    output.stdout,
+		Stderr:    output.stderr,
+		Combined:  output.combined,
+	}
+	defer func() {
 		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to parse turbostat output")
+			capture.Error = err.Error()
 		}
+		debugStore.Set(capture)
+	}()
 
-		log.Debug().Msgf("Found %d headers, %d data lines", len(headers), len(rows))
-		log.Debug().Msgf("Headers: %s", headers)
+	if runErr != nil {
+		return fmt.Errorf("failed to run turbostat: %w", runErr)
+	}
 
-		parsedRows := parser.ParseRowsSimple(headers, rows)
+	headers, rows, warnings, err := internal.ParseTurbostatOutputWithWarnings(output.combined)
+	capture.Headers = headers
+	capture.Warnings = warnings
+	if err != nil {
+		return fmt.Errorf("failed to parse turbostat output: %w", err)
+	}
 
-		extractedCategories := "Categories found - "
-		// Debug: print how many rows are in each category
-		for _, cat := range []string{"package", "core", "cpu", "total"} {
-			catRows := parsedRows[cat]
-			extractedCategories += fmt.Sprintf("%s: %d, ", cat, len(catRows))
-		}
+	log.Debug().Msgf("Found %d headers, %d data lines", len(headers), len(rows))
+	log.Debug().Msgf("Headers: %s", headers)
 
-		log.Debug().Msgf("%s", extractedCategories)
+	parsedRows := parser.ParseRowsSimple(headers, rows)
+	capture.AnalyzeRows(parser.ParseCategories(headers, rows), rows, parsedRows)
 
-		// Collect all rows from all categories
-		allRows := make([]internal.TurbostatRow, 0)
-		for _, v := range parsedRows {
-			for _, r := range v {
-				allRows = append(allRows, *r)
-			}
-		}
-		exporter.Update(allRows)
+	extractedCategories := "Categories found - "
+	// Debug: print how many rows are in each category
+	for _, cat := range []string{"package", "core", "cpu", "total"} {
+		catRows := parsedRows[cat]
+		extractedCategories += fmt.Sprintf("%s: %d, ", cat, len(catRows))
 	}
-}
 
-func startServer(ctx context.Context, updateFunc func(time.Duration)) {
-	fmt.Println("Prometheus turbostat exporter - created by BlackDark (https://github.com/BlackDark/prometheus_turbostat_exporter)")
-	parseConfiguration()
+	log.Debug().Msgf("%s", extractedCategories)
 
-	updateFunc(0)
+	// Collect all rows from all categories
+	allRows := internal.FlattenRows(parsedRows)
+	capture.AnalyzeColumns(allRows, parser.ColumnAllowed)
+	exporter.Update(allRows)
 
-	if isBackgroundMode {
+	snapshotStore.Set(&internal.Snapshot{
+		Timestamp: time.Now(),
+		Duration:  time.Since(start),
+		Headers:   headers,
+		Rows:      allRows,
+	})
+	return nil
+}
+
+// startCollecting starts the collections of the configured mode. The first
+// collection runs while the server is already listening, so /healthz answers
+// right away and /readyz flips once it finished.
+func startCollecting(ctx context.Context, updateFunc func(time.Duration)) {
+	switch {
+	case streamSource != nil:
+		// collections are driven by the stream
+	case !isBackgroundMode:
+		go updateFunc(0)
+	default:
 		log.Debug().Msgf("Starting ticker")
 		ticker := time.NewTicker(backgroundCollectInterval)
 
 		go func() {
+			updateFunc(0)
 			updateFunc(defaultSleepTimer)
 			for {
 				select {
@@ -111,56 +415,244 @@ func startServer(ctx context.Context, updateFunc func(time.Duration)) {
 			}
 		}()
 	}
+}
+
+// startServer serves HTTP until ctx is done.
+func startServer(ctx context.Context, updateFunc func(time.Duration)) {
+	// Concurrent scrapes (e.g. an HA Prometheus pair) share one collection
+	// instead of starting several turbostat processes skewing each other.
+	scrapeCache := internal.NewScrapeCache(func() { updateFunc(defaultSleepTimer) }, activeMaxAge, activeMinSpacing)
+
+	gatherer := prometheus.Gatherer(prometheus.DefaultGatherer)
+	var ingestStore *internal.IngestStore
+	if ingestEnabled {
+		ingestStore = internal.NewIngestStore(ingestStaleness, ingestMaxInstances, newIngestExporter, metricsRegisterer())
+		gatherer = prometheus.Gatherers{prometheus.DefaultGatherer, ingestStore}
+	}
+	// the headers of the latest collection and of the ingested instances
+	// resolve the modules of C-states and custom counters
+	latestColumns := func() []string {
+		var columns []string
+		if snap := snapshotStore.Latest(); snap != nil {
+			columns = snap.Headers
+		}
+		if ingestStore != nil {
+			columns = append(slices.Clip(columns), ingestStore.Columns()...)
+		}
+		return columns
+	}
+	promHandler := promhttp.InstrumentMetricHandler(prometheus.WrapRegistererWith(constantLabels, prometheus.DefaultRegisterer),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			modules := defaultModules
+			if values := r.URL.Query()["collect[]"]; len(values) > 0 {
+				var err error
+				if modules, err = internal.ParseModules(values); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			g := internal.ModuleGatherer(gatherer, metricPrefix, modules, latestColumns)
+			promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+			// scrapes without the aggregated columns, e.g. another job using
+			// collect[], must not end the window of the scrapes with them
+			if aggregator != nil && slices.ContainsFunc(aggregateOptions.Columns, func(c string) bool {
+				return slices.Contains(modules, internal.ColumnModule(c))
+			}) {
+				aggregator.Rotate()
+			}
+		}))
 
 	metricsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !isBackgroundMode {
-			updateFunc(defaultSleepTimer)
+		if !isBackgroundMode && streamSource == nil {
+			scrapeCache.Collect(r.Context())
 		}
-		promhttp.Handler().ServeHTTP(w, r)
+		promHandler.ServeHTTP(w, r)
 	})
 
-	if basicAuthEnabled {
-		metricsHandler = internal.BasicAuth(metricsHandler, basicAuthUsername, basicAuthPassword)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler)
+	mux.Handle("/healthz", internal.HealthzHandler())
+	mux.Handle("/readyz", internal.ReadyzHandler(collectionStatus, readyMaxAge))
+	mux.Handle("/api/v1/snapshot", internal.SnapshotHandler(snapshotStore))
+	links := []internal.LandingLink{
+		{Path: "/metrics", Description: "Prometheus metrics"},
+		{Path: "/healthz", Description: "Liveness probe"},
+		{Path: "/readyz", Description: "Readiness probe"},
+		{Path: "/api/v1/snapshot", Description: "Latest parsed turbostat rows as JSON"},
+	}
+
+	if historyStore != nil {
+		mux.Handle("/api/v1/history", internal.HistoryHandler(historyStore))
+		links = append(links, internal.LandingLink{Path: "/api/v1/history", Description: "Recent values of a metric with ?metric=<column>&from=<time>&step=<duration>"})
+	}
+
+	if broadcaster != nil {
+		mux.Handle("/dashboard", internal.DashboardHandler())
+		mux.Handle("/api/v1/stream", internal.StreamHandler(broadcaster))
+		links = append(links,
+			internal.LandingLink{Path: "/dashboard", Description: "Live dashboard with per-CPU heatmaps"},
+			internal.LandingLink{Path: "/api/v1/stream", Description: "Server-Sent Events with every collection as JSON"})
+	}
+
+	if probeEnabled {
+		mux.Handle("/probe", internal.ProbeHandler(runProbe, probeLimits, int(defaultSleepTimer/time.Second), turbostatInvocation.Options, newExporter))
+		links = append(links, internal.LandingLink{Path: "/probe", Description: "Run turbostat with per-request seconds, show/hide and cpu parameters"})
 	}
 
-	http.Handle("/metrics", metricsHandler)
+	authChain := createAuthChain()
+	if debugEndpointEnabled {
+		if !authChain.Enabled() {
+			log.Fatal().Msg("TURBOSTAT_DEBUG_ENDPOINT_ENABLED requires an authentication backend to be configured")
+		}
+		mux.Handle("/debug/turbostat", internal.DebugHandler(debugStore))
+		links = append(links, internal.LandingLink{Path: "/debug/turbostat", Description: "Raw turbostat output and parser decisions"})
+	}
+
+	if ingestStore != nil {
+		if !authChain.Enabled() {
+			log.Fatal().Msg("TURBOSTAT_INGEST_ENABLED requires an authentication backend to be configured")
+		}
+		mux.Handle("/api/v1/ingest", internal.IngestHandler(ingestStore, ingestMaxBytes))
+		links = append(links, internal.LandingLink{Path: "/api/v1/ingest", Description: "POST raw turbostat output of another host with ?instance=<name>"})
+	}
+
+	mux.Handle("/", internal.NewLandingPageHandler(internal.LandingPageConfig{
+		Version:  Version,
+		Settings: configurationSummary(),
+		Links:    links,
+	}, collectionStatus, readyMaxAge))
+
 	log.Info().Msgf("Starting server on %s", listenAddr)
 	server := &http.Server{
 		Addr:              listenAddr,
+		Handler:           authChain.Middleware(mux),
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       10 * time.Second,
 		// In non-background mode each request runs turbostat synchronously for
-		// defaultSleepTimer, so the write deadline must cover that plus overhead.
-		WriteTimeout: defaultSleepTimer + 30*time.Second,
+		// defaultSleepTimer (or up to the probe limit on /probe), so the write
+		// deadline must cover that plus overhead.
+		WriteTimeout: longestRequestDuration() + 30*time.Second,
+	}
+	if broadcaster != nil {
+		server.RegisterOnShutdown(broadcaster.Close)
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Warn().Err(err).Msg("Failed to shut down the server gracefully")
+		}
+	}()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal().Err(err).Msg("")
+	}
+}
+
+// createAuthChain combines all configured authentication backends. Without any
+// backend the chain lets every request through.
+func createAuthChain() *internal.AuthChain {
+	var backends []internal.AuthBackend
+
+	if basicAuthEnabled {
+		backends = append(backends, internal.NewBasicAuthBackend(basicAuthUsername, basicAuthPassword))
+	}
+
+	if len(bearerTokens) > 0 || bearerTokenFile != "" {
+		backend, err := internal.NewBearerTokenBackend(bearerTokens, bearerTokenFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to set up bearer token auth")
+		}
+		backends = append(backends, backend)
+		log.Info().Msg("Enabled bearer token auth")
 	}
-	log.Fatal().Err(server.ListenAndServe()).Msg("")
+
+	if htpasswdFile != "" {
+		backend, err := internal.NewHtpasswdBackend(htpasswdFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to set up htpasswd auth")
+		}
+		backends = append(backends, backend)
+		log.Info().Msgf("Enabled htpasswd auth using %s", htpasswdFile)
+	}
+
+	return internal.NewAuthChain(internal.AuthChainOptions{
+		ExemptPaths:   authExemptPaths,
+		MaxFailures:   authMaxFailures,
+		FailureWindow: authFailureWindow,
+		Registerer:    metricsRegisterer(),
+	}, backends...)
 }
 
-func executeProgram(collectTimeSeconds int) (string, error) {
+// longestRequestDuration is the longest time a request may spend running turbostat.
+func longestRequestDuration() time.Duration {
+	if probeEnabled {
+		return max(defaultSleepTimer, time.Duration(probeLimits.MaxSeconds)*time.Second)
+	}
+	return defaultSleepTimer
+}
+
+// runProbe executes turbostat on behalf of a /probe request.
+func runProbe(ctx context.Context, seconds int, opts internal.TurbostatOptions) (string, error) {
+	output, err := executeProgram(ctx, seconds, opts)
+	return output.combined, err
+}
+
+// programOutput is the output of a turbostat run. turbostat prints its data to
+// stderr, so the parser works on combined, which interleaves both streams.
+type programOutput struct {
+	command  string
+	stdout   string
+	stderr   string
+	combined string
+}
+
+// lockedWriter serializes writes from the stdout and stderr copy goroutines
+// into the shared combined buffer.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func executeProgram(ctx context.Context, collectTimeSeconds int, opts internal.TurbostatOptions) (programOutput, error) {
 	var cmd *exec.Cmd
 
 	if isCommandCat {
 		content, err := os.ReadFile("data/sandy-bridge.tsv")
 		if err != nil {
-			return "", err
+			return programOutput{command: "cat data/sandy-bridge.tsv"}, err
 		}
-		return string(content), nil
+		return programOutput{command: "cat data/sandy-bridge.tsv", stdout: string(content), combined: string(content)}, nil
 	}
-	// Use /bin/sh -c to run turbostat as a child of the shell, not Go
-	turbostatCmd := fmt.Sprintf("turbostat --quiet sleep %d", collectTimeSeconds)
-	cmd = exec.Command("/bin/sh", "-c", turbostatCmd)
-	log.Trace().Msgf("Executing command: %s", turbostatCmd)
+	args := turbostatInvocation.Argv(collectTimeSeconds, opts)
+	cmd = exec.CommandContext(ctx, turbostatInvocation.Binary, args...)
+	command := turbostatInvocation.Binary + " " + strings.Join(args, " ")
+	log.Trace().Msgf("Executing command: %s", command)
 
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
+	var stdout, stderr, combined bytes.Buffer
+	combinedWriter := &lockedWriter{w: &combined}
+	cmd.Stdout = io.MultiWriter(&stdout, combinedWriter)
+	cmd.Stderr = io.MultiWriter(&stderr, combinedWriter)
 
-	if err := cmd.Run(); err != nil {
-		log.Fatal().Err(err).Msg("Failed to run turbostat")
-		return "", err
+	err := cmd.Run()
+	output := programOutput{
+		command:  command,
+		stdout:   stdout.String(),
+		stderr:   stderr.String(),
+		combined: combined.String(),
+	}
+	if err != nil {
+		return output, fmt.Errorf("%w: %s", err, strings.TrimSpace(output.combined))
 	}
 
-	return out.String(), nil
+	return output, nil
 }
 
 func parseConfiguration() {
@@ -202,6 +694,140 @@ func parseConfiguration() {
 		}
 	}
 
+	if val, ok := os.LookupEnv("TURBOSTAT_BINARY"); ok && val != "" {
+		turbostatInvocation.Binary = val
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_EXTRA_ARGS"); ok {
+		turbostatInvocation.ExtraArgs = strings.Fields(val)
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_SHOW"); ok {
+		turbostatInvocation.Options.Show = splitList(val)
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_HIDE"); ok {
+		turbostatInvocation.Options.Hide = splitList(val)
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_CPU"); ok {
+		turbostatInvocation.Options.CPUs = strings.TrimSpace(val)
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_INPUT_FIFO"); ok {
+		inputFIFO = val
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_SAMPLE_INTERVAL_SECONDS"); ok && val != "" {
+		if convertVal, err := strconv.ParseFloat(val, 64); err == nil && convertVal >= 0.1 {
+			sampleInterval = time.Duration(convertVal * float64(time.Second))
+		} else {
+			log.Fatal().Msgf("TURBOSTAT_SAMPLE_INTERVAL_SECONDS must be a number of at least 0.1, got %q", val)
+		}
+		if inputFIFO != "" || readFromStdin || isCommandCat {
+			log.Fatal().Msg("TURBOSTAT_SAMPLE_INTERVAL_SECONDS runs turbostat itself and can't be combined with TURBOSTAT_INPUT_FIFO, --stdin or TURBOSTAT_EXPORTER_DEBUG_CAT_EXEC")
+		}
+	}
+	parseAggregateConfiguration()
+
+	include := os.Getenv("TURBOSTAT_EXPORT_COLUMNS_INCLUDE")
+	exclude := os.Getenv("TURBOSTAT_EXPORT_COLUMNS_EXCLUDE")
+	levels, levelsSet := os.LookupEnv("TURBOSTAT_EXPORT_LEVELS")
+	if include != "" || exclude != "" || (levelsSet && levels != "") {
+		enabledLevels := internal.Levels
+		if levels != "" {
+			enabledLevels = splitList(levels)
+		}
+		f, err := internal.NewSeriesFilter(include, exclude, enabledLevels)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid TURBOSTAT_EXPORT_* configuration")
+		}
+		seriesFilter = &f
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_METRIC_PREFIX"); ok && val != "" {
+		prefix, err := internal.ParseMetricPrefix(val)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid TURBOSTAT_METRIC_PREFIX")
+		}
+		metricPrefix = prefix
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_CONSTANT_LABELS"); ok && val != "" {
+		labels, err := internal.ParseConstLabels(val)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid TURBOSTAT_CONSTANT_LABELS")
+		}
+		constantLabels = labels
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_COLLECT_DEFAULT_MODULES"); ok && val != "" {
+		modules, err := internal.ParseModules([]string{val})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid TURBOSTAT_COLLECT_DEFAULT_MODULES")
+		}
+		defaultModules = modules
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_SERIES_LIMIT"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= 0 {
+			seriesLimits.Total = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_SERIES_LIMIT must be a non-negative integer. Using default: %d", seriesLimits.Total)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_SERIES_LIMIT_PER_FAMILY"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= 0 {
+			seriesLimits.PerFamily = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_SERIES_LIMIT_PER_FAMILY must be a non-negative integer. Using default: %d", seriesLimits.PerFamily)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_CPU_HISTOGRAMS_ENABLED"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			cpuHistogramsEnabled = convertVal
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_CPU_HISTOGRAM_COLUMNS"); ok && val != "" {
+		cpuHistogramOptions.Columns = splitList(val)
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_CPU_HISTOGRAM_TYPE"); ok && val != "" {
+		switch val {
+		case "native":
+			cpuHistogramOptions.Classic = false
+		case "classic":
+			cpuHistogramOptions.Native = false
+		case "both":
+		default:
+			log.Fatal().Msgf("TURBOSTAT_CPU_HISTOGRAM_TYPE must be native, classic or both, got %q", val)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_CUSTOM_COUNTERS_FILE"); ok && val != "" {
+		counters, err := internal.LoadCustomCounters(val)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid custom counter configuration")
+		}
+		for _, c := range counters {
+			turbostatInvocation.ExtraArgs = append(turbostatInvocation.ExtraArgs, "--add", c.AddArg())
+			internal.RegisterColumn(c.ColumnInfo())
+		}
+		customCounters = counters
+		log.Info().Msgf("Configured %d custom counters", len(counters))
+	}
+
+	// the once and inspect commands report an invalid invocation with its own exit code
+	if !isCommandCat && inputFIFO == "" && !readFromStdin && !oneShot {
+		if err := turbostatInvocation.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("Invalid turbostat configuration")
+		}
+		log.Info().Msgf("Using turbostat %s", turbostatInvocation.Binary)
+	}
+
 	if val, ok := os.LookupEnv("TURBOSTAT_COLLECT_IN_BACKGROUND"); ok {
 		if convertVal, err := strconv.ParseBool(val); err == nil {
 			isBackgroundMode = convertVal
@@ -216,10 +842,28 @@ func parseConfiguration() {
 		}
 	}
 
-	if isBackgroundMode {
+	if val, ok := os.LookupEnv("TURBOSTAT_ACTIVE_MAX_AGE_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= 0 {
+			activeMaxAge = time.Duration(convertVal) * time.Second
+		} else {
+			log.Warn().Msgf("TURBOSTAT_ACTIVE_MAX_AGE_SECONDS must be a non-negative integer. Using default: %s", activeMaxAge)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_ACTIVE_MIN_SPACING_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= 0 {
+			activeMinSpacing = time.Duration(convertVal) * time.Second
+		} else {
+			log.Warn().Msgf("TURBOSTAT_ACTIVE_MIN_SPACING_SECONDS must be a non-negative integer. Using default: %s", activeMinSpacing)
+		}
+	}
+
+	if readFromStdin || inputFIFO != "" || sampleInterval > 0 {
+		log.Info().Msgf("Collections are driven by the turbostat output stream.")
+	} else if isBackgroundMode {
 		log.Info().Msgf("Running collector in background with interval %s.", backgroundCollectInterval)
 	} else {
-		log.Info().Msgf("Running collector in active mode (on request will execute turbostat)")
+		log.Info().Msgf("Running collector in active mode (on request will execute turbostat, max age %s, min spacing %s)", activeMaxAge, activeMinSpacing)
 	}
 
 	// use the default if not set
@@ -247,7 +891,574 @@ func parseConfiguration() {
 		log.Info().Msg("Enabled basic auth")
 	}
 
+	if val, ok := os.LookupEnv("TURBOSTAT_AUTH_BEARER_TOKENS"); ok {
+		bearerTokens = splitList(val)
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_AUTH_BEARER_TOKEN_FILE"); ok {
+		bearerTokenFile = val
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_AUTH_HTPASSWD_FILE"); ok {
+		htpasswdFile = val
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_AUTH_EXEMPT_PATHS"); ok {
+		authExemptPaths = splitList(val)
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_AUTH_MAX_FAILURES"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= 0 {
+			authMaxFailures = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_AUTH_MAX_FAILURES must be a non-negative integer. Using default: %d", authMaxFailures)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_AUTH_FAILURE_WINDOW_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			authFailureWindow = time.Duration(convertVal) * time.Second
+		} else {
+			log.Warn().Msgf("TURBOSTAT_AUTH_FAILURE_WINDOW_SECONDS must be a positive integer. Using default: %s", authFailureWindow)
+		}
+	}
+
 	if val, ok := os.LookupEnv("TURBOSTAT_LISTEN_ADDR"); ok {
 		listenAddr = val
 	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_HTTP_ENABLED"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			httpEnabled = convertVal
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_TEXTFILE_DIR"); ok && val != "" {
+		name := "turbostat.prom"
+		if n, ok := os.LookupEnv("TURBOSTAT_TEXTFILE_NAME"); ok && n != "" {
+			name = n
+		}
+		textfilePath = filepath.Join(val, name)
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_TEXTFILE_MODE"); ok {
+		if convertVal, err := strconv.ParseUint(val, 8, 32); err == nil && convertVal <= 0o777 {
+			textfileMode = os.FileMode(convertVal)
+		} else {
+			log.Warn().Msgf("TURBOSTAT_TEXTFILE_MODE must be an octal file mode like 0644. Using default: %#o", textfileMode)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PUSHGATEWAY_URL"); ok {
+		pushgatewayURL = val
+	}
+	pushgatewayAuth = pushAuthFromEnv("TURBOSTAT_PUSHGATEWAY")
+	if val, ok := os.LookupEnv("TURBOSTAT_PUSHGATEWAY_DELETE_ON_SHUTDOWN"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			pushgatewayDelete = convertVal
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_REMOTE_WRITE_URL"); ok {
+		remoteWriteURL = val
+	}
+	remoteWriteAuth = pushAuthFromEnv("TURBOSTAT_REMOTE_WRITE")
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PUSH_JOB"); ok && val != "" {
+		pushJob = val
+	}
+	pushInstance, _ = os.Hostname()
+	if val, ok := os.LookupEnv("TURBOSTAT_PUSH_INSTANCE"); ok && val != "" {
+		pushInstance = val
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PUSH_BUFFER_SIZE"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			pushOptions.BufferSize = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_PUSH_BUFFER_SIZE must be a positive integer. Using default: %d", pushOptions.BufferSize)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PUSH_MAX_RETRIES"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= 0 {
+			pushOptions.MaxRetries = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_PUSH_MAX_RETRIES must be a non-negative integer. Using default: %d", pushOptions.MaxRetries)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PUSH_TIMEOUT_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			pushTimeout = time.Duration(convertVal) * time.Second
+		} else {
+			log.Warn().Msgf("TURBOSTAT_PUSH_TIMEOUT_SECONDS must be a positive integer. Using default: %s", pushTimeout)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_OTLP_ENDPOINT"); ok {
+		otlpConfig.Endpoint = val
+	}
+	if val, ok := os.LookupEnv("TURBOSTAT_OTLP_PROTOCOL"); ok && val != "" {
+		otlpConfig.Protocol = val
+	}
+	if val, ok := os.LookupEnv("TURBOSTAT_OTLP_HEADERS"); ok {
+		otlpConfig.Headers = map[string]string{}
+		for _, header := range splitList(val) {
+			name, value, found := strings.Cut(header, "=")
+			if !found {
+				log.Warn().Msgf("Ignoring OTLP header %q, expected name=value", name)
+				continue
+			}
+			otlpConfig.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	otlpConfig.Timeout = pushTimeout
+
+	parseMQTTConfiguration()
+	parseInfluxConfiguration()
+
+	if val, ok := os.LookupEnv("TURBOSTAT_DASHBOARD_ENABLED"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			dashboardEnabled = convertVal
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_DASHBOARD_MAX_CLIENTS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			dashboardMaxClients = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_DASHBOARD_MAX_CLIENTS must be a positive integer. Using default: %d", dashboardMaxClients)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_HISTORY_ENABLED"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			historyEnabled = convertVal
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_HISTORY_RETENTION_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			historyRetention = time.Duration(convertVal) * time.Second
+		} else {
+			log.Warn().Msgf("TURBOSTAT_HISTORY_RETENTION_SECONDS must be a positive integer. Using default: %s", historyRetention)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_HISTORY_RESOLUTION_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			historyResolution = time.Duration(convertVal) * time.Second
+		} else {
+			log.Warn().Msgf("TURBOSTAT_HISTORY_RESOLUTION_SECONDS must be a positive integer. Using default: %s", historyResolution)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_HISTORY_MAX_SERIES"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			historyMaxSeries = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_HISTORY_MAX_SERIES must be a positive integer. Using default: %d", historyMaxSeries)
+		}
+	}
+
+	if !httpEnabled && !oneShot {
+		if textfilePath == "" && pushgatewayURL == "" && remoteWriteURL == "" && otlpConfig.Endpoint == "" && mqttConfig.Broker == "" && influxConfig.URL == "" {
+			log.Fatal().Msg("TURBOSTAT_HTTP_ENABLED=false requires an output like TURBOSTAT_TEXTFILE_DIR, TURBOSTAT_PUSHGATEWAY_URL, TURBOSTAT_REMOTE_WRITE_URL, TURBOSTAT_OTLP_ENDPOINT, TURBOSTAT_MQTT_BROKER or TURBOSTAT_INFLUX_URL")
+		}
+		if !isBackgroundMode && inputFIFO == "" && !readFromStdin && sampleInterval == 0 {
+			log.Fatal().Msg("TURBOSTAT_HTTP_ENABLED=false requires background collection or a stream input, active mode collects on scrapes")
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_DEBUG_ENDPOINT_ENABLED"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			debugEndpointEnabled = convertVal
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PROBE_ENABLED"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			probeEnabled = convertVal
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PROBE_MIN_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			probeLimits.MinSeconds = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_PROBE_MIN_SECONDS must be a positive integer. Using default: %d", probeLimits.MinSeconds)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PROBE_MAX_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= probeLimits.MinSeconds {
+			probeLimits.MaxSeconds = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_PROBE_MAX_SECONDS must be an integer of at least TURBOSTAT_PROBE_MIN_SECONDS. Using default: %d", probeLimits.MaxSeconds)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PROBE_ALLOWED_COLUMNS"); ok {
+		probeLimits.AllowedColumns = splitList(val)
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_PROBE_MAX_CONCURRENT"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			probeLimits.MaxConcurrent = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_PROBE_MAX_CONCURRENT must be a positive integer. Using default: %d", probeLimits.MaxConcurrent)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_INGEST_ENABLED"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			ingestEnabled = convertVal
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_INGEST_STALENESS_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			ingestStaleness = time.Duration(convertVal) * time.Second
+		} else {
+			log.Warn().Msgf("TURBOSTAT_INGEST_STALENESS_SECONDS must be a positive integer. Using default: %s", ingestStaleness)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_INGEST_MAX_BYTES"); ok {
+		if convertVal, err := strconv.ParseInt(val, 10, 64); err == nil && convertVal > 0 {
+			ingestMaxBytes = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_INGEST_MAX_BYTES must be a positive integer. Using default: %d", ingestMaxBytes)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_INGEST_MAX_INSTANCES"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= 0 {
+			ingestMaxInstances = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_INGEST_MAX_INSTANCES must be a non-negative integer. Using default: %d", ingestMaxInstances)
+		}
+	}
+
+	// In background mode a collection is expected every interval, so allow a
+	// few missed ticks. In active mode collections only happen on scrapes.
+	if isBackgroundMode {
+		readyMaxAge = 3*backgroundCollectInterval + defaultSleepTimer
+	}
+	if val, ok := os.LookupEnv("TURBOSTAT_READY_MAX_AGE_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= 0 {
+			readyMaxAge = time.Duration(convertVal) * time.Second
+		} else {
+			log.Warn().Msgf("TURBOSTAT_READY_MAX_AGE_SECONDS must be a non-negative integer. Using default: %s", readyMaxAge)
+		}
+	}
+}
+
+// configurationSummary lists the effective settings for the landing page.
+// Secrets are never included.
+func configurationSummary() []internal.LandingSetting {
+	authBackends := []string{}
+	if basicAuthEnabled {
+		authBackends = append(authBackends, "basic")
+	}
+	if len(bearerTokens) > 0 || bearerTokenFile != "" {
+		authBackends = append(authBackends, "bearer")
+	}
+	if htpasswdFile != "" {
+		authBackends = append(authBackends, "htpasswd")
+	}
+	if len(authBackends) == 0 {
+		authBackends = append(authBackends, "none")
+	}
+
+	mode := fmt.Sprintf("active (turbostat runs on scrape, max age %s, min spacing %s)", activeMaxAge, activeMinSpacing)
+	if isBackgroundMode {
+		mode = fmt.Sprintf("background (every %s)", backgroundCollectInterval)
+	}
+	if streamSource != nil {
+		mode = fmt.Sprintf("stream (reading turbostat output from %s)", streamSource.Name())
+	}
+	if sampleInterval > 0 {
+		mode = fmt.Sprintf("sampling (turbostat prints every %s)", sampleInterval)
+	}
+
+	return []internal.LandingSetting{
+		{Name: "Listen address", Value: listenAddr},
+		{Name: "Collection mode", Value: mode},
+		{Name: "Collect duration", Value: defaultSleepTimer.String()},
+		{Name: "Debug cat mode", Value: strconv.FormatBool(isCommandCat)},
+		{Name: "turbostat", Value: turbostatInvocation.Binary + " " + strings.Join(turbostatInvocation.Argv(int(defaultSleepTimer/time.Second), internal.TurbostatOptions{}), " ")},
+		{Name: "Authentication", Value: strings.Join(authBackends, ", ")},
+		{Name: "Ready max age", Value: readyMaxAge.String()},
+		{Name: "Debug endpoint", Value: strconv.FormatBool(debugEndpointEnabled)},
+		{Name: "Probe endpoint", Value: strconv.FormatBool(probeEnabled)},
+		{Name: "Ingest endpoint", Value: ingestSetting()},
+		{Name: "Textfile output", Value: textfileSetting()},
+		{Name: "Pushgateway", Value: redactedURL(pushgatewayURL)},
+		{Name: "Remote write", Value: redactedURL(remoteWriteURL)},
+		{Name: "OTLP", Value: otlpSetting()},
+		{Name: "MQTT", Value: redactedURL(mqttConfig.Broker)},
+		{Name: "InfluxDB", Value: redactedURL(influxConfig.URL)},
+		{Name: "History", Value: historySetting()},
+		{Name: "Aggregation", Value: aggregateSetting()},
+		{Name: "CPU histograms", Value: cpuHistogramSetting()},
+		{Name: "Series filter", Value: seriesFilterSetting()},
+		{Name: "Metric prefix", Value: metricPrefix},
+		{Name: "Constant labels", Value: constantLabelsSetting()},
+		{Name: "Default modules", Value: strings.Join(defaultModules, ", ") + " (select with /metrics?collect[]=…)"},
+		{Name: "Series limits", Value: fmt.Sprintf("total %d, per family %d (0 = no limit)", seriesLimits.Total, seriesLimits.PerFamily)},
+	}
+}
+
+func constantLabelsSetting() string {
+	if len(constantLabels) == 0 {
+		return "none"
+	}
+	pairs := make([]string, 0, len(constantLabels))
+	for _, name := range slices.Sorted(maps.Keys(constantLabels)) {
+		pairs = append(pairs, name+"="+constantLabels[name])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func seriesFilterSetting() string {
+	if seriesFilter == nil {
+		return "none"
+	}
+	var parts []string
+	if seriesFilter.Include != nil {
+		parts = append(parts, "include "+seriesFilter.Include.String())
+	}
+	if seriesFilter.Exclude != nil {
+		parts = append(parts, "exclude "+seriesFilter.Exclude.String())
+	}
+	if len(seriesFilter.DisabledLevels) > 0 {
+		parts = append(parts, "without "+strings.Join(seriesFilter.DisabledLevels, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func cpuHistogramSetting() string {
+	if !cpuHistogramsEnabled {
+		return "disabled"
+	}
+	var types []string
+	if cpuHistogramOptions.Native {
+		types = append(types, "native")
+	}
+	if cpuHistogramOptions.Classic {
+		types = append(types, "classic")
+	}
+	return fmt.Sprintf("%s (%s)", strings.Join(cpuHistogramOptions.Columns, ", "), strings.Join(types, ", "))
+}
+
+func aggregateSetting() string {
+	if len(aggregateOptions.Columns) == 0 {
+		return "disabled"
+	}
+	window := "since the previous scrape"
+	if aggregateOptions.Window > 0 {
+		window = "over " + aggregateOptions.Window.String()
+	}
+	return fmt.Sprintf("%s %s", strings.Join(aggregateOptions.Columns, ", "), window)
+}
+
+func historySetting() string {
+	if !historyEnabled {
+		return "disabled"
+	}
+	return fmt.Sprintf("%s at %s resolution", historyRetention, historyResolution)
+}
+
+func otlpSetting() string {
+	if otlpConfig.Endpoint == "" {
+		return "disabled"
+	}
+	return fmt.Sprintf("%s (%s)", redactedURL(otlpConfig.Endpoint), otlpConfig.Protocol)
+}
+
+// redactedURL hides a password contained in the URL.
+func redactedURL(val string) string {
+	if val == "" {
+		return "disabled"
+	}
+	u, err := url.Parse(val)
+	if err != nil {
+		return "invalid URL"
+	}
+	return u.Redacted()
+}
+
+func textfileSetting() string {
+	if textfilePath == "" {
+		return "disabled"
+	}
+	return fmt.Sprintf("%s (mode %#o)", textfilePath, textfileMode)
+}
+
+func ingestSetting() string {
+	if !ingestEnabled {
+		return "false"
+	}
+	return fmt.Sprintf("true (staleness %s, max %d instances)", ingestStaleness, ingestMaxInstances)
+}
+
+func parseInfluxConfiguration() {
+	if val, ok := os.LookupEnv("TURBOSTAT_INFLUX_URL"); ok {
+		influxConfig.URL = val
+	}
+	if influxConfig.URL == "" {
+		return
+	}
+
+	influxConfig.Database = os.Getenv("TURBOSTAT_INFLUX_DATABASE")
+	influxConfig.RetentionPolicy = os.Getenv("TURBOSTAT_INFLUX_RETENTION_POLICY")
+	influxConfig.Username = os.Getenv("TURBOSTAT_INFLUX_USERNAME")
+	influxConfig.Password = os.Getenv("TURBOSTAT_INFLUX_PASSWORD")
+	influxConfig.Org = os.Getenv("TURBOSTAT_INFLUX_ORG")
+	influxConfig.Bucket = os.Getenv("TURBOSTAT_INFLUX_BUCKET")
+	influxConfig.Token = os.Getenv("TURBOSTAT_INFLUX_TOKEN")
+	influxConfig.Measurement = os.Getenv("TURBOSTAT_INFLUX_MEASUREMENT")
+
+	influxConfig.Tags = map[string]string{"host": pushInstance}
+	if val, ok := os.LookupEnv("TURBOSTAT_INFLUX_TAGS"); ok {
+		for _, tag := range splitList(val) {
+			name, value, found := strings.Cut(tag, "=")
+			if !found {
+				log.Warn().Msgf("Ignoring InfluxDB tag %q, expected name=value", name)
+				continue
+			}
+			influxConfig.Tags[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	influxConfig.BatchSize = 5000
+	if val, ok := os.LookupEnv("TURBOSTAT_INFLUX_BATCH_SIZE"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			influxConfig.BatchSize = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_INFLUX_BATCH_SIZE must be a positive integer. Using default: %d", influxConfig.BatchSize)
+		}
+	}
+	influxConfig.Timeout = pushTimeout
+}
+
+func parseAggregateConfiguration() {
+	if val, ok := os.LookupEnv("TURBOSTAT_AGGREGATE_COLUMNS"); ok {
+		aggregateOptions.Columns = splitList(val)
+	}
+	if len(aggregateOptions.Columns) == 0 {
+		return
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_AGGREGATE_WINDOW_SECONDS"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal >= 0 {
+			aggregateOptions.Window = time.Duration(convertVal) * time.Second
+		} else {
+			log.Warn().Msgf("TURBOSTAT_AGGREGATE_WINDOW_SECONDS must be a non-negative integer. Using default: %s", aggregateOptions.Window)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_AGGREGATE_QUANTILES"); ok {
+		for _, q := range splitList(val) {
+			convertVal, err := strconv.ParseFloat(q, 64)
+			if err != nil || convertVal < 0 || convertVal > 1 {
+				log.Fatal().Msgf("TURBOSTAT_AGGREGATE_QUANTILES must be numbers between 0 and 1, got %q", q)
+			}
+			aggregateOptions.Quantiles = append(aggregateOptions.Quantiles, convertVal)
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_AGGREGATE_MAX_SAMPLES"); ok {
+		if convertVal, err := strconv.Atoi(val); err == nil && convertVal > 0 {
+			aggregateOptions.MaxSamples = convertVal
+		} else {
+			log.Warn().Msgf("TURBOSTAT_AGGREGATE_MAX_SAMPLES must be a positive integer. Using default: %d", aggregateOptions.MaxSamples)
+		}
+	}
+
+	if sampleInterval == 0 {
+		log.Warn().Msg("TURBOSTAT_AGGREGATE_COLUMNS without TURBOSTAT_SAMPLE_INTERVAL_SECONDS only aggregates the regular collections")
+	}
+}
+
+// pushAuthFromEnv reads <prefix>_USERNAME, <prefix>_PASSWORD and
+// <prefix>_BEARER_TOKEN.
+func pushAuthFromEnv(prefix string) internal.PushAuth {
+	return internal.PushAuth{
+		Username:    os.Getenv(prefix + "_USERNAME"),
+		Password:    os.Getenv(prefix + "_PASSWORD"),
+		BearerToken: os.Getenv(prefix + "_BEARER_TOKEN"),
+	}
+}
+
+// parseMQTTConfiguration reads the TURBOSTAT_MQTT_* settings of the MQTT
+// publisher.
+func parseMQTTConfiguration() {
+	if val, ok := os.LookupEnv("TURBOSTAT_MQTT_BROKER"); ok {
+		mqttConfig.Broker = val
+	}
+	if mqttConfig.Broker == "" {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	mqttConfig.NodeID = hostname
+	if val, ok := os.LookupEnv("TURBOSTAT_MQTT_NODE_ID"); ok && val != "" {
+		mqttConfig.NodeID = val
+	}
+	mqttConfig.ClientID = "turbostat-exporter-" + mqttConfig.NodeID
+	if val, ok := os.LookupEnv("TURBOSTAT_MQTT_CLIENT_ID"); ok && val != "" {
+		mqttConfig.ClientID = val
+	}
+	mqttConfig.TopicPrefix = "turbostat/" + mqttConfig.NodeID
+	if val, ok := os.LookupEnv("TURBOSTAT_MQTT_TOPIC_PREFIX"); ok && val != "" {
+		mqttConfig.TopicPrefix = val
+	}
+	mqttConfig.Username = os.Getenv("TURBOSTAT_MQTT_USERNAME")
+	mqttConfig.Password = os.Getenv("TURBOSTAT_MQTT_PASSWORD")
+
+	if val, ok := os.LookupEnv("TURBOSTAT_MQTT_QOS"); ok {
+		if convertVal, err := strconv.ParseUint(val, 10, 8); err == nil && convertVal <= 2 {
+			mqttConfig.QoS = byte(convertVal)
+		} else {
+			log.Warn().Msgf("TURBOSTAT_MQTT_QOS must be 0, 1 or 2. Using default: %d", mqttConfig.QoS)
+		}
+	}
+	if val, ok := os.LookupEnv("TURBOSTAT_MQTT_RETAIN"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			mqttConfig.Retain = convertVal
+		}
+	}
+
+	mqttConfig.CAFile = os.Getenv("TURBOSTAT_MQTT_CA_FILE")
+	mqttConfig.CertFile = os.Getenv("TURBOSTAT_MQTT_CERT_FILE")
+	mqttConfig.KeyFile = os.Getenv("TURBOSTAT_MQTT_KEY_FILE")
+	if val, ok := os.LookupEnv("TURBOSTAT_MQTT_TLS_INSECURE_SKIP_VERIFY"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			mqttConfig.InsecureSkipVerify = convertVal
+		}
+	}
+
+	if val, ok := os.LookupEnv("TURBOSTAT_MQTT_DISCOVERY_ENABLED"); ok {
+		if convertVal, err := strconv.ParseBool(val); err == nil {
+			mqttConfig.Discovery = convertVal
+		}
+	}
+	if val, ok := os.LookupEnv("TURBOSTAT_MQTT_DISCOVERY_PREFIX"); ok && val != "" {
+		mqttConfig.DiscoveryPrefix = val
+	}
+	mqttConfig.Timeout = pushTimeout
+}
+
+// splitList splits a comma separated environment value and drops empty entries.
+func splitList(val string) []string {
+	var res []string
+	for item := range strings.SplitSeq(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
 }
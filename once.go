@@ -0,0 +1,74 @@
+package main
+
+import (
+	"blackdark/turbostat-exporter/internal"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Exit codes of the once command.
+const (
+	exitOK              = 0
+	exitOutputFailed    = 1
+	exitUsage           = 2
+	exitTurbostatFailed = 3
+	exitParseFailed     = 4
+)
+
+// runOnce runs a single collection, prints the result to stdout and returns
+// the exit code. Logs go to stderr, so stdout only contains the result.
+func runOnce(args []string) int {
+	flags := flag.NewFlagSet("once", flag.ContinueOnError)
+	seconds := flags.Int("seconds", int(defaultSleepTimer/time.Second), "seconds turbostat collects")
+	format := flags.String("format", "prom", "output format: "+strings.Join(internal.OutputFormats, ", "))
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+		return exitUsage
+	}
+	if *seconds < 1 {
+		fmt.Fprintln(os.Stderr, "--seconds must be a positive integer")
+		return exitUsage
+	}
+	if !slices.Contains(internal.OutputFormats, *format) {
+		fmt.Fprintf(os.Stderr, "--format must be one of %s\n", strings.Join(internal.OutputFormats, ", "))
+		return exitUsage
+	}
+
+	if !isCommandCat {
+		if err := turbostatInvocation.Validate(); err != nil {
+			log.Error().Err(err).Msg("Invalid turbostat configuration")
+			return exitTurbostatFailed
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	exporter := newExporter(registry)
+
+	start := time.Now()
+	output, err := executeProgram(context.Background(), *seconds, internal.TurbostatOptions{})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to run turbostat")
+		return exitTurbostatFailed
+	}
+	if err := processOutput(newParser(), exporter, start, output, nil); err != nil {
+		log.Error().Err(err).Msg("Collection failed")
+		return exitParseFailed
+	}
+
+	if err := internal.WriteSnapshot(os.Stdout, *format, snapshotStore.Latest(), registry); err != nil {
+		log.Error().Err(err).Msg("Failed to write output")
+		return exitOutputFailed
+	}
+	return exitOK
+}
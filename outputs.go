@@ -0,0 +1,91 @@
+package main
+
+import (
+	"blackdark/turbostat-exporter/internal"
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// setupOutputs registers the configured outputs besides /metrics. They run
+// after every successful collection and are closed on shutdown.
+func setupOutputs(ctx context.Context) {
+	gatherer := internal.TurbostatGatherer(prometheus.DefaultGatherer, metricPrefix)
+
+	if textfilePath != "" {
+		writer, err := internal.NewTextfileWriter(textfilePath, textfileMode, gatherer)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid textfile collector output")
+		}
+		afterCollection = append(afterCollection, func() {
+			if err := writer.Write(); err != nil {
+				log.Error().Err(err).Msgf("Failed to write %s", writer.Path())
+			}
+		})
+		log.Info().Msgf("Writing metrics to %s after each collection", writer.Path())
+	}
+
+	if pushgatewayURL != "" {
+		target := internal.NewPushgatewayTarget(pushgatewayURL, pushJob, pushInstance, pushgatewayAuth, pushTimeout)
+		// the Pushgateway only keeps the latest push, older collections are useless
+		opts := pushOptions
+		opts.BufferSize = 1
+		startPusher(ctx, target, gatherer, opts, pushgatewayDelete)
+		log.Info().Msgf("Pushing metrics to Pushgateway %s as job %q, instance %q", pushgatewayURL, pushJob, pushInstance)
+	}
+
+	if remoteWriteURL != "" {
+		labels := map[string]string{"job": pushJob, "instance": pushInstance}
+		target := internal.NewRemoteWriteTarget(remoteWriteURL, labels, remoteWriteAuth, pushTimeout)
+		startPusher(ctx, target, gatherer, pushOptions, false)
+		log.Info().Msgf("Pushing metrics to remote write endpoint %s", remoteWriteURL)
+	}
+
+	if influxConfig.URL != "" {
+		target, err := internal.NewInfluxTarget(influxConfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid InfluxDB configuration")
+		}
+		startPusher(ctx, target, nil, pushOptions, false)
+		log.Info().Msgf("Writing line protocol to %s", redactedURL(influxConfig.URL))
+	}
+
+	if otlpConfig.Endpoint != "" {
+		otlpConfig.Version = Version
+		exporter, err := internal.NewOTLPExporter(ctx, otlpConfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid OTLP configuration")
+		}
+		go exporter.Run(ctx)
+		afterCollection = append(afterCollection, func() { exporter.Record(snapshotStore.Latest()) })
+		onShutdown = append(onShutdown, func(ctx context.Context) {
+			if err := exporter.Shutdown(ctx); err != nil {
+				log.Error().Err(err).Msg("Failed to shut down OTLP export")
+			}
+		})
+		log.Info().Msgf("Exporting metrics over OTLP (%s) to %s", otlpConfig.Protocol, redactedURL(otlpConfig.Endpoint))
+	}
+
+	if mqttConfig.Broker != "" {
+		mqttConfig.Version = Version
+		publisher, err := internal.NewMQTTPublisher(mqttConfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid MQTT configuration")
+		}
+		publisher.Connect()
+		go publisher.Run(ctx)
+		afterCollection = append(afterCollection, func() { publisher.Record(snapshotStore.Latest()) })
+		onShutdown = append(onShutdown, publisher.Close)
+		log.Info().Msgf("Publishing metrics to MQTT broker %s below %s", redactedURL(mqttConfig.Broker), mqttConfig.TopicPrefix)
+	}
+}
+
+func startPusher(ctx context.Context, target internal.PushTarget, gatherer prometheus.Gatherer, opts internal.PushOptions, deleteOnShutdown bool) {
+	opts.Registerer = metricsRegisterer()
+	pusher := internal.NewPusher(target, gatherer, opts)
+	go pusher.Run(ctx)
+
+	afterCollection = append(afterCollection, func() { pusher.Enqueue(snapshotStore.Latest()) })
+	onShutdown = append(onShutdown, func(ctx context.Context) { pusher.Close(ctx, deleteOnShutdown) })
+}